@@ -2,15 +2,25 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
+	"github.com/ten00m/golang-test-task/internal/absence"
 	"github.com/ten00m/golang-test-task/internal/config"
+	"github.com/ten00m/golang-test-task/internal/http-server/handlers"
 	"github.com/ten00m/golang-test-task/internal/logger"
+	"github.com/ten00m/golang-test-task/internal/metrics"
 	"github.com/ten00m/golang-test-task/internal/router"
 	"github.com/ten00m/golang-test-task/internal/storage"
+	"github.com/ten00m/golang-test-task/internal/tracing"
 )
 
 func main() {
@@ -18,6 +28,18 @@ func main() {
 
 	log := logger.New(os.Stdout)
 
+	if flag.Arg(0) == "migrate" {
+		if err := runMigrate(cfg, log, flag.Args()[1:]); err != nil {
+			if errors.Is(err, errMigrateUsage) {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(2)
+			}
+			log.Error("migration failed", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+		return
+	}
+
 	defer func() {
 		if r := recover(); r != nil {
 			log.Error("panic recovered", slog.Any("error", r))
@@ -26,48 +48,108 @@ func main() {
 
 	log.Info("configuration loaded",
 		slog.String("http_addr", cfg.HTTPServer.Address),
+		slog.String("storage_driver", cfg.Storage.Driver),
 	)
 
-	db, err := storage.New(&cfg.PostgreSQL, log)
-	if err != nil {
-		log.Error("failed to initialize storage", slog.String("error", err.Error()))
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := run(ctx, cfg, log); err != nil {
+		log.Error("server stopped with error", slog.String("error", err.Error()))
 		os.Exit(1)
 	}
+}
+
+// run serves the API until ctx is done or the server fails. It then stops
+// accepting connections, waits up to the shutdown timeout for in-flight
+// requests, stops the background workers and closes the storage.
+func run(ctx context.Context, cfg *config.Config, log *slog.Logger) error {
+	shutdownTracing, err := tracing.Setup(ctx, cfg.Tracing, log)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.HTTPServer.ShutdownTimeout)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			log.Error("failed to flush traces", slog.String("error", err.Error()))
+		}
+	}()
+
+	db, err := storage.NewFromConfig(ctx, cfg, log)
+	if err != nil {
+		return fmt.Errorf("failed to initialize storage: %w", err)
+	}
 	defer func() {
 		if err := db.Close(); err != nil {
 			log.Error("failed to close storage", slog.String("error", err.Error()))
 		}
 	}()
 
-	r := router.New(log, db)
+	m := metrics.New(log, db)
+	store := m.Instrument(db)
+
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	var workers sync.WaitGroup
+	defer func() {
+		stopWorkers()
+		workers.Wait()
+		log.Info("background workers stopped")
+	}()
+
+	probe := &handlers.Probe{Timeout: cfg.HTTPServer.ReadyTimeout}
+
+	if cfg.Absences.CheckInterval > 0 {
+		worker := absence.NewWorker(log, store, cfg.Absences.CheckInterval)
+		probe.AddWorker(worker)
+		workers.Go(func() {
+			worker.Run(workerCtx)
+		})
+	}
+
+	// Bulk routes get the usual budget on top of their storage limit.
+	var bulkWriteTimeout time.Duration
+	if cfg.Storage.BulkQueryTimeout > 0 {
+		bulkWriteTimeout = cfg.HTTPServer.Timeout + cfg.Storage.BulkQueryTimeout
+	}
 
 	srv := &http.Server{
 		Addr:         cfg.HTTPServer.Address,
-		Handler:      r,
+		Handler:      router.New(log, store, cfg.Auth.AdminToken, m, probe, bulkWriteTimeout),
 		ReadTimeout:  cfg.HTTPServer.Timeout,
 		WriteTimeout: cfg.HTTPServer.Timeout,
 		IdleTimeout:  cfg.HTTPServer.IdleTimeout,
 	}
 
-	errorChan := make(chan struct{})
-
+	serveErr := make(chan error, 1)
 	go func() {
 		log.Info("starting http server", slog.String("addr", srv.Addr))
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Error("server is not started with error", slog.Any("error", err))
-			errorChan <- struct{}{}
-		}
+		serveErr <- srv.ListenAndServe()
 	}()
 
-	<-errorChan
+	select {
+	case err := <-serveErr:
+		return fmt.Errorf("http server failed: %w", err)
+	case <-ctx.Done():
+		log.Info("shutting down", slog.String("timeout", cfg.HTTPServer.ShutdownTimeout.String()))
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	// Fail readiness first and keep serving for a while, so that load
+	// balancers take us out of rotation before connections are refused.
+	probe.ShutDown()
+	if cfg.HTTPServer.DrainDelay > 0 {
+		log.Info("draining", slog.String("delay", cfg.HTTPServer.DrainDelay.String()))
+		time.Sleep(cfg.HTTPServer.DrainDelay)
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.HTTPServer.ShutdownTimeout)
 	defer cancel()
 
-	if err := srv.Shutdown(ctx); err != nil {
-		log.Error("graceful shutdown failed", slog.String("error", err.Error()))
-		os.Exit(1)
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		_ = srv.Close()
+		return fmt.Errorf("graceful shutdown failed: %w", err)
 	}
 
 	log.Info("server stopped")
+	return nil
 }
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ilyakaznacheev/cleanenv"
+
+	"github.com/ten00m/golang-test-task/internal/config"
+)
+
+// syncBuffer collects the log output of run, which writes from several
+// goroutines.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func freeAddress(t *testing.T) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+func TestRunShutsDownGracefully(t *testing.T) {
+	var cfg config.Config
+	if err := cleanenv.ReadEnv(&cfg); err != nil {
+		t.Fatalf("read config defaults: %v", err)
+	}
+	cfg.HTTPServer.Address = freeAddress(t)
+	cfg.HTTPServer.DrainDelay = 500 * time.Millisecond
+	cfg.HTTPServer.ShutdownTimeout = 5 * time.Second
+	cfg.Storage.Driver = config.DriverMemory
+	cfg.Auth.AdminToken = ""
+	cfg.Absences.CheckInterval = time.Hour
+	cfg.Tracing.Exporter = "none"
+
+	var logs syncBuffer
+	log := slog.New(slog.NewTextHandler(&logs, nil))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- run(ctx, &cfg, log)
+	}()
+
+	client := &http.Client{Timeout: time.Second, Transport: &http.Transport{DisableKeepAlives: true}}
+	base := "http://" + cfg.HTTPServer.Address
+	status := func(path string) int {
+		resp, err := client.Get(base + path)
+		if err != nil {
+			return 0
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	for deadline := time.Now().Add(5 * time.Second); status("/readyz") != http.StatusOK; {
+		if time.Now().After(deadline) {
+			t.Fatalf("service did not become ready:\n%s", logs.String())
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// The request stays in flight until the rest of its body is sent.
+	body := `{"team_name":"slow","members":[{"user_id":"u1","username":"alice","is_active":true}]}`
+	conn, err := net.Dial("tcp", cfg.HTTPServer.Address)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "POST /team/add HTTP/1.1\r\nHost: test\r\nContent-Type: application/json\r\nContent-Length: %d\r\n\r\n%s", len(body), body[:10])
+
+	cancel()
+	stopped := time.Now()
+
+	sawNotReady := false
+	for time.Since(stopped) < cfg.HTTPServer.DrainDelay {
+		if status("/readyz") == http.StatusServiceUnavailable {
+			sawNotReady = true
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if !sawNotReady {
+		t.Error("expected /readyz to return 503 while draining")
+	}
+
+	time.Sleep(time.Until(stopped.Add(cfg.HTTPServer.DrainDelay + 200*time.Millisecond)))
+	select {
+	case err := <-done:
+		t.Fatalf("run returned with a request in flight: %v", err)
+	default:
+	}
+
+	if _, err := conn.Write([]byte(body[10:])); err != nil {
+		t.Fatalf("finish request: %v", err)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("read response: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Errorf("expected the in-flight request to complete with 201, got %d", resp.StatusCode)
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("run: %v", err)
+		}
+	case <-time.After(cfg.HTTPServer.ShutdownTimeout):
+		t.Fatal("run did not return within the shutdown timeout")
+	}
+
+	out := logs.String()
+	for _, msg := range []string{"background workers stopped", "in-memory storage closed"} {
+		if !strings.Contains(out, msg) {
+			t.Errorf("expected %q in the logs:\n%s", msg, out)
+		}
+	}
+}
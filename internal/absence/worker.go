@@ -0,0 +1,99 @@
+// Package absence runs the job that hands over the open reviews of users
+// whose absence has started.
+package absence
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+
+	"github.com/ten00m/golang-test-task/internal/http-server/handlers"
+	"github.com/ten00m/golang-test-task/internal/tracing"
+)
+
+// Actor is recorded in the audit log for the reassignments of the worker.
+const Actor = "absence-worker"
+
+type reassigner interface {
+	ReassignAbsentReviewers(ctx context.Context, actor string) (*handlers.ReassignReport, error)
+}
+
+// Worker reassigns the reviews of absent users every interval.
+type Worker struct {
+	log      *slog.Logger
+	r        reassigner
+	interval time.Duration
+
+	mu      sync.Mutex
+	lastRun time.Time
+	lastErr error
+}
+
+func NewWorker(log *slog.Logger, r reassigner, interval time.Duration) *Worker {
+	return &Worker{
+		log:      log.With(slog.String("op", "absence.Worker")),
+		r:        r,
+		interval: interval,
+	}
+}
+
+// Run reassigns the reviews of absent users right away and then every
+// interval until ctx is done.
+func (w *Worker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		runCtx, span := otel.Tracer(tracing.Name).Start(ctx, "absence.ReassignAbsentReviewers")
+		report, err := w.r.ReassignAbsentReviewers(runCtx, Actor)
+		span.End()
+		if ctx.Err() != nil {
+			return
+		}
+
+		w.mu.Lock()
+		w.lastRun, w.lastErr = time.Now(), err
+		w.mu.Unlock()
+
+		if err != nil {
+			w.log.Error("failed to reassign reviews of absent users", slog.Any("error", err))
+		} else if len(report.Reassigned)+len(report.Failed) > 0 {
+			w.log.Info("reviews of absent users reassigned",
+				slog.Int("reassigned", len(report.Reassigned)),
+				slog.Int("failed", len(report.Failed)),
+			)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Health reports the outcome of the last run. The worker is unhealthy only
+// when no run has finished for two intervals: a failed run is reported but
+// retried on the next tick, and it must not take every replica out of
+// rotation until then.
+func (w *Worker) Health() handlers.WorkerHealth {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	health := handlers.WorkerHealth{Name: "absence", Healthy: true}
+	if !w.lastRun.IsZero() {
+		lastRun := w.lastRun.UTC()
+		health.LastRun = &lastRun
+	}
+	if w.lastErr != nil {
+		health.LastError = w.lastErr.Error()
+	}
+	if !w.lastRun.IsZero() && time.Since(w.lastRun) > 2*w.interval {
+		health.Healthy = false
+		health.LastError = "no run finished for " + time.Since(w.lastRun).Round(time.Second).String()
+	}
+	return health
+}
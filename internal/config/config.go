@@ -11,21 +11,118 @@ import (
 
 type Config struct {
 	HTTPServer HTTPServerConfig `yaml:"http_server"`
+	Storage    StorageConfig    `yaml:"storage"`
 	PostgreSQL PostgreSQLConfig `yaml:"psql_info"`
+	SQLite     SQLiteConfig     `yaml:"sqlite_info"`
+	Reviewers  ReviewersConfig  `yaml:"reviewers"`
+	Auth       AuthConfig       `yaml:"auth"`
+	Absences   AbsencesConfig   `yaml:"absences"`
+	Tracing    TracingConfig    `yaml:"tracing"`
 }
 
 type HTTPServerConfig struct {
 	Address     string        `yaml:"address" env:"HTTP_ADDRESS" env-default:"localhost:8080"`
 	Timeout     time.Duration `yaml:"timeout" env:"HTTP_TIMEOUT" env-default:"6s"`
 	IdleTimeout time.Duration `yaml:"idle_timeout" env:"HTTP_IDLE_TIMEOUT" env-default:"60s"`
+	// ShutdownTimeout is how long in-flight requests may take to finish
+	// once the server is asked to stop.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"HTTP_SHUTDOWN_TIMEOUT" env-default:"10s"`
+	// DrainDelay is how long /readyz fails before the server stops
+	// accepting connections, giving load balancers time to notice.
+	DrainDelay time.Duration `yaml:"drain_delay" env:"HTTP_DRAIN_DELAY" env-default:"0s"`
+	// ReadyTimeout bounds the dependency checks of /readyz.
+	ReadyTimeout time.Duration `yaml:"ready_timeout" env:"HTTP_READY_TIMEOUT" env-default:"2s"`
+}
+
+const (
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite"
+	DriverMemory   = "memory"
+)
+
+type StorageConfig struct {
+	Driver      string `yaml:"driver" env:"STORAGE_DRIVER" env-default:"postgres"`
+	AutoMigrate bool   `yaml:"auto_migrate" env:"STORAGE_AUTO_MIGRATE" env-default:"true"`
+	// QueryTimeout bounds each storage call, transaction included. Zero
+	// disables the limit.
+	QueryTimeout time.Duration `yaml:"query_timeout" env:"STORAGE_QUERY_TIMEOUT" env-default:"5s"`
+	// BulkQueryTimeout replaces QueryTimeout for the calls that hand over
+	// the reviews of users: deactivation, team moves and the absence
+	// worker. Their routes get a write deadline of http_server.timeout plus
+	// this limit instead of http_server.timeout alone.
+	BulkQueryTimeout time.Duration `yaml:"bulk_query_timeout" env:"STORAGE_BULK_QUERY_TIMEOUT" env-default:"1m"`
 }
 
 type PostgreSQLConfig struct {
+	// DSN is a full connection string, either a postgres:// URL or
+	// key=value pairs. When set, it is used as is instead of the
+	// connection settings below.
+	DSN      string `yaml:"dsn" env:"PSQL_DSN"`
 	Host     string `yaml:"host" env:"PSQL_HOST" env-default:"localhost"`
 	Port     int    `yaml:"port" env:"PSQL_PORT" env-default:"5432"`
 	User     string `yaml:"user" env:"PSQL_USER" env-default:"postgres"`
 	Password string `yaml:"password" env:"PSQL_PASSWORD"`
 	Database string `yaml:"database" env:"PSQL_DATABASE"`
+
+	// SSLMode is one of disable, require, verify-ca or verify-full.
+	SSLMode     string `yaml:"sslmode" env:"PSQL_SSLMODE" env-default:"disable"`
+	SSLRootCert string `yaml:"sslrootcert" env:"PSQL_SSLROOTCERT"`
+	SSLCert     string `yaml:"sslcert" env:"PSQL_SSLCERT"`
+	SSLKey      string `yaml:"sslkey" env:"PSQL_SSLKEY"`
+
+	MaxOpenConns    int           `yaml:"max_open_conns" env:"PSQL_MAX_OPEN_CONNS" env-default:"25"`
+	MaxIdleConns    int           `yaml:"max_idle_conns" env:"PSQL_MAX_IDLE_CONNS" env-default:"25"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime" env:"PSQL_CONN_MAX_LIFETIME" env-default:"30m"`
+	// ConnectTimeout is how long startup keeps retrying to reach the
+	// database before giving up. Zero tries once.
+	ConnectTimeout time.Duration `yaml:"connect_timeout" env:"PSQL_CONNECT_TIMEOUT" env-default:"30s"`
+}
+
+type SQLiteConfig struct {
+	Path        string        `yaml:"path" env:"SQLITE_PATH" env-default:"golang-test-task.db"`
+	BusyTimeout time.Duration `yaml:"busy_timeout" env:"SQLITE_BUSY_TIMEOUT" env-default:"5s"`
+}
+
+type ReviewersConfig struct {
+	// Strategy is used by teams that have not picked a reviewer_strategy.
+	Strategy string `yaml:"strategy" env:"REVIEWERS_STRATEGY" env-default:"random"`
+	// MaxOpenReviews is the open review cap for users without their own;
+	// 0 disables it.
+	MaxOpenReviews int `yaml:"max_open_reviews" env:"REVIEWERS_MAX_OPEN_REVIEWS" env-default:"0"`
+	// ReassignOnDeactivate moves the open reviews of deactivated users to
+	// other reviewers.
+	ReassignOnDeactivate bool `yaml:"reassign_on_deactivate" env:"REVIEWERS_REASSIGN_ON_DEACTIVATE" env-default:"true"`
+}
+
+type AuthConfig struct {
+	// AdminToken is expected in the X-Admin-Token header of admin-only
+	// requests, such as forced merges. Empty disables them.
+	AdminToken string `yaml:"admin_token" env:"ADMIN_TOKEN"`
+}
+
+type AbsencesConfig struct {
+	// CheckInterval is how often the open reviews of users whose absence
+	// has started are handed over; 0 disables it.
+	CheckInterval time.Duration `yaml:"check_interval" env:"ABSENCES_CHECK_INTERVAL" env-default:"10m"`
+}
+
+const (
+	TracingNone   = "none"
+	TracingOTLP   = "otlp"
+	TracingStdout = "stdout"
+	TracingFile   = "file"
+)
+
+type TracingConfig struct {
+	// Exporter is one of none, otlp, stdout or file.
+	Exporter    string `yaml:"exporter" env:"TRACING_EXPORTER" env-default:"none"`
+	ServiceName string `yaml:"service_name" env:"TRACING_SERVICE_NAME" env-default:"golang-test-task"`
+	// Endpoint is the OTLP/HTTP collector URL; empty leaves it to the
+	// standard OTEL_EXPORTER_OTLP_* variables.
+	Endpoint string `yaml:"endpoint" env:"TRACING_ENDPOINT"`
+	// File receives the spans of the file exporter as JSON lines.
+	File        string  `yaml:"file" env:"TRACING_FILE" env-default:"traces.jsonl"`
+	SampleRatio float64 `yaml:"sample_ratio" env:"TRACING_SAMPLE_RATIO" env-default:"1"`
 }
 
 // LoadConfig loads configuration from a YAML file specified by flag or environment variable
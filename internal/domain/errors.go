@@ -0,0 +1,62 @@
+// Package domain holds the errors storage backends return for violated
+// business rules. Handlers map them to API error codes with
+// response.FromError, so the messages below are what clients see.
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrTeamExists   = errors.New("team_name already exists")
+	ErrTeamNotFound = errors.New("team not found")
+	ErrTeamNotEmpty = errors.New("team still has members")
+	ErrTeamInUse    = errors.New("team is a fallback team of other teams")
+
+	ErrUnknownStrategy = errors.New("unknown reviewer strategy")
+	ErrInvalidSettings = errors.New("invalid settings")
+
+	ErrUserNotFound     = errors.New("user not found")
+	ErrUsernameTaken    = errors.New("username already taken")
+	ErrAuthorNotFound   = errors.New("author not found")
+	ErrReviewerNotFound = errors.New("reviewer not found")
+	ErrUserInOtherTeam  = errors.New("user is a member of another team")
+	ErrUserHasOpenPRs   = errors.New("user has open pull requests")
+
+	ErrInvalidAbsence  = errors.New("absence must end after it starts")
+	ErrAbsenceNotFound = errors.New("absence not found")
+
+	ErrPRExists    = errors.New("PR id already exists")
+	ErrPRNotFound  = errors.New("PR not found")
+	ErrPRMerged    = errors.New("cannot reassign on merged PR")
+	ErrPRIsMerged  = errors.New("PR is already merged")
+	ErrPRDraft     = errors.New("PR is a draft")
+	ErrPRClosed    = errors.New("PR is closed")
+	ErrNotAssigned = errors.New("reviewer is not assigned to this PR")
+	ErrNoCandidate = errors.New("no active replacement candidate in team")
+
+	ErrNotEnoughReviewers = errors.New("not enough active reviewer candidates in team")
+
+	ErrInvalidReviewState = errors.New("invalid review state")
+	ErrInvalidSort        = errors.New("invalid sort order")
+	ErrReviewMerged       = errors.New("cannot review merged PR")
+
+	ErrMergeBlocked = errors.New("merge blocked by team merge policy")
+)
+
+// MergeBlockedError is returned when a PR does not satisfy the merge policy
+// of its author's team. It matches ErrMergeBlocked with errors.Is.
+type MergeBlockedError struct {
+	// Unmet describes every condition of the policy the PR fails.
+	Unmet []string
+}
+
+func (e *MergeBlockedError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrMergeBlocked, strings.Join(e.Unmet, "; "))
+}
+
+func (e *MergeBlockedError) Is(target error) bool {
+	return target == ErrMergeBlocked
+}
@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/render"
+	mwAuth "github.com/ten00m/golang-test-task/internal/http-server/middleware/auth"
+	mwLogger "github.com/ten00m/golang-test-task/internal/http-server/middleware/logger"
+	resp "github.com/ten00m/golang-test-task/internal/lib/api/response"
+)
+
+// Absence is a window in which a user is away. Reviewers are not picked
+// among users inside one, and their open reviews are handed over when it
+// starts.
+type Absence struct {
+	ID     int64     `json:"absence_id"`
+	UserID string    `json:"user_id"`
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+type absenceAdder interface {
+	AddAbsence(ctx context.Context, actor string, absence Absence) (*Absence, error)
+}
+
+func NewUsersAddAbsence(log *slog.Logger, aa absenceAdder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.users.addAbsence"
+
+		log := mwLogger.FromContext(r.Context(), log).With(slog.String("op", op))
+
+		var req Absence
+
+		err := render.DecodeJSON(r.Body, &req)
+		if err != nil {
+			log.Error("Failed to decode request body", slog.Any("error", err))
+			w.WriteHeader(http.StatusBadRequest)
+			render.JSON(w, r, resp.ErrorResponse("Failed to decode request", resp.StatusError))
+			return
+		}
+
+		absence, err := aa.AddAbsence(r.Context(), mwAuth.Actor(r.Context()), req)
+		if err != nil {
+			log.Error("Failed to add absence", slog.Any("error", err))
+
+			status, body := resp.FromError(err)
+			w.WriteHeader(status)
+			render.JSON(w, r, body)
+			return
+		}
+
+		log.Info("Absence added", slog.String("user_id", absence.UserID), slog.Int64("absence_id", absence.ID))
+
+		w.WriteHeader(http.StatusCreated)
+		render.JSON(w, r, map[string]interface{}{"absence": absence})
+	}
+}
+
+type absencesGetter interface {
+	GetAbsences(ctx context.Context, userID string) ([]Absence, error)
+}
+
+// NewUsersGetAbsences lists the absences of a user that have not ended yet.
+func NewUsersGetAbsences(log *slog.Logger, ag absencesGetter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.users.getAbsences"
+
+		log := mwLogger.FromContext(r.Context(), log).With(slog.String("op", op))
+
+		userID := r.URL.Query().Get("user_id")
+		if userID == "" {
+			log.Warn("missing user_id query param")
+			w.WriteHeader(http.StatusBadRequest)
+			render.JSON(w, r, resp.ErrorResponse("user_id query param required", resp.CodeNotFound))
+			return
+		}
+
+		absences, err := ag.GetAbsences(r.Context(), userID)
+		if err != nil {
+			log.Error("Failed to get absences", slog.Any("error", err))
+
+			status, body := resp.FromError(err)
+			w.WriteHeader(status)
+			render.JSON(w, r, body)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		render.JSON(w, r, map[string]interface{}{
+			"user_id":  userID,
+			"absences": absences,
+		})
+	}
+}
+
+type absenceDeleter interface {
+	DeleteAbsence(ctx context.Context, actor string, absenceID int64) error
+}
+
+func NewUsersDeleteAbsence(log *slog.Logger, ad absenceDeleter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.users.deleteAbsence"
+
+		log := mwLogger.FromContext(r.Context(), log).With(slog.String("op", op))
+
+		var req struct {
+			AbsenceID int64 `json:"absence_id"`
+		}
+
+		err := render.DecodeJSON(r.Body, &req)
+		if err != nil {
+			log.Error("Failed to decode request body", slog.Any("error", err))
+			w.WriteHeader(http.StatusBadRequest)
+			render.JSON(w, r, resp.ErrorResponse("Failed to decode request", resp.StatusError))
+			return
+		}
+
+		if err := ad.DeleteAbsence(r.Context(), mwAuth.Actor(r.Context()), req.AbsenceID); err != nil {
+			log.Error("Failed to delete absence", slog.Any("error", err))
+
+			status, body := resp.FromError(err)
+			w.WriteHeader(status)
+			render.JSON(w, r, body)
+			return
+		}
+
+		log.Info("Absence deleted", slog.Int64("absence_id", req.AbsenceID))
+
+		w.WriteHeader(http.StatusOK)
+		render.JSON(w, r, map[string]interface{}{"absence_id": req.AbsenceID})
+	}
+}
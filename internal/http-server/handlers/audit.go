@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/render"
+	mwLogger "github.com/ten00m/golang-test-task/internal/http-server/middleware/logger"
+	resp "github.com/ten00m/golang-test-task/internal/lib/api/response"
+)
+
+// Audit actions recorded by storage.
+const (
+	AuditTeamCreated         = "team.created"
+	AuditTeamSettingsUpdated = "team.settings_updated"
+	AuditTeamMemberAdded     = "team.member_added"
+	AuditTeamMemberRemoved   = "team.member_removed"
+	AuditTeamRenamed         = "team.renamed"
+	AuditTeamDeleted         = "team.deleted"
+	AuditUserActivated       = "user.activated"
+	AuditUserDeactivated     = "user.deactivated"
+	AuditUserMoved           = "user.moved"
+	AuditUserAbsenceAdded    = "user.absence_added"
+	AuditUserAbsenceDeleted  = "user.absence_deleted"
+	AuditPRCreated           = "pr.created"
+	AuditPRReady             = "pr.ready"
+	AuditPRClosed            = "pr.closed"
+	AuditPRReopened          = "pr.reopened"
+	AuditPRMerged            = "pr.merged"
+	AuditReviewerAssigned    = "reviewer.assigned"
+	AuditReviewerReassigned  = "reviewer.reassigned"
+	AuditReviewSubmitted     = "review.submitted"
+)
+
+type AuditEvent struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+	// Actor is the X-Actor-ID of the request that caused the event.
+	Actor         string `json:"actor,omitempty"`
+	Action        string `json:"action"`
+	PRID          string `json:"pull_request_id,omitempty"`
+	UserID        string `json:"user_id,omitempty"`
+	OldReviewerID string `json:"old_reviewer_id,omitempty"`
+	NewReviewerID string `json:"new_reviewer_id,omitempty"`
+	TeamName      string `json:"team_name,omitempty"`
+	Details       string `json:"details,omitempty"`
+}
+
+// AuditFilter selects audit events. Empty fields match everything.
+type AuditFilter struct {
+	PRID string
+	// UserID matches the user an event is about as well as the old and new
+	// reviewer.
+	UserID string
+	Since  time.Time
+	// After is the cursor: only events with a greater ID are returned.
+	After int64
+	Limit int
+}
+
+type AuditPage struct {
+	Events []AuditEvent `json:"events"`
+	// NextCursor is passed as cursor to get the next page; nil on the last
+	// page.
+	NextCursor *int64 `json:"next_cursor,omitempty"`
+}
+
+const (
+	defaultAuditLimit = 50
+	maxAuditLimit     = 500
+)
+
+type auditGetter interface {
+	GetAuditEvents(ctx context.Context, filter AuditFilter) (*AuditPage, error)
+}
+
+func NewGetAudit(log *slog.Logger, ag auditGetter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.audit.get"
+
+		log := mwLogger.FromContext(r.Context(), log).With(slog.String("op", op))
+
+		q := r.URL.Query()
+		filter := AuditFilter{
+			PRID:   q.Get("pr_id"),
+			UserID: q.Get("user_id"),
+			Limit:  defaultAuditLimit,
+		}
+
+		var err error
+		if since := q.Get("since"); since != "" {
+			if filter.Since, err = time.Parse(time.RFC3339, since); err != nil {
+				log.Warn("invalid since query param", slog.String("since", since))
+				w.WriteHeader(http.StatusBadRequest)
+				render.JSON(w, r, resp.ErrorResponse("since must be an RFC 3339 timestamp", resp.CodeInvalidQuery))
+				return
+			}
+		}
+		if cursor := q.Get("cursor"); cursor != "" {
+			if filter.After, err = strconv.ParseInt(cursor, 10, 64); err != nil || filter.After < 0 {
+				log.Warn("invalid cursor query param", slog.String("cursor", cursor))
+				w.WriteHeader(http.StatusBadRequest)
+				render.JSON(w, r, resp.ErrorResponse("invalid cursor", resp.CodeInvalidQuery))
+				return
+			}
+		}
+		if limit := q.Get("limit"); limit != "" {
+			if filter.Limit, err = strconv.Atoi(limit); err != nil || filter.Limit < 1 || filter.Limit > maxAuditLimit {
+				log.Warn("invalid limit query param", slog.String("limit", limit))
+				w.WriteHeader(http.StatusBadRequest)
+				render.JSON(w, r, resp.ErrorResponse("limit must be between 1 and 500", resp.CodeInvalidQuery))
+				return
+			}
+		}
+
+		page, err := ag.GetAuditEvents(r.Context(), filter)
+		if err != nil {
+			log.Error("Failed to get audit events", slog.Any("error", err))
+
+			status, body := resp.FromError(err)
+			w.WriteHeader(status)
+			render.JSON(w, r, body)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		render.JSON(w, r, page)
+	}
+}
@@ -1,9 +1,130 @@
 package handlers
 
 import (
+	"context"
+	"log/slog"
 	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/render"
+
+	mwLogger "github.com/ten00m/golang-test-task/internal/http-server/middleware/logger"
 )
 
+// HealthCheck is kept for clients of the old probe; it behaves like Livez.
 func HealthCheck(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
+
+// Livez tells that the process is up and serving HTTP. It checks nothing
+// else, so that a broken dependency does not get the service restarted.
+func Livez(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	render.JSON(w, r, map[string]interface{}{"status": "ok"})
+}
+
+// MigrationState tells how far the schema is migrated.
+type MigrationState struct {
+	Current int `json:"current"`
+	Latest  int `json:"latest"`
+	Pending int `json:"pending"`
+}
+
+type WorkerHealth struct {
+	Name      string     `json:"name"`
+	Healthy   bool       `json:"healthy"`
+	LastRun   *time.Time `json:"last_run,omitempty"`
+	LastError string     `json:"last_error,omitempty"`
+}
+
+type Readiness struct {
+	Status       string          `json:"status"`
+	ShuttingDown bool            `json:"shutting_down"`
+	Database     string          `json:"database"`
+	Error        string          `json:"error,omitempty"`
+	Migrations   *MigrationState `json:"migrations,omitempty"`
+	Workers      []WorkerHealth  `json:"workers"`
+}
+
+type healthReporter interface {
+	Health() WorkerHealth
+}
+
+// Probe holds what readiness depends on besides storage.
+type Probe struct {
+	// Timeout bounds the storage checks of one readiness request.
+	Timeout time.Duration
+
+	workers []healthReporter
+
+	shuttingDown atomic.Bool
+}
+
+// ShutDown makes readiness fail from now on, so that load balancers stop
+// sending requests before the server stops accepting them.
+func (p *Probe) ShutDown() {
+	p.shuttingDown.Store(true)
+}
+
+// AddWorker adds a background worker whose health readiness reports.
+func (p *Probe) AddWorker(w healthReporter) {
+	p.workers = append(p.workers, w)
+}
+
+type storageChecker interface {
+	Ping(ctx context.Context) error
+	MigrationState(ctx context.Context) (*MigrationState, error)
+}
+
+// NewReadyz reports whether the service can take traffic: storage answers
+// within the probe timeout, no migration is pending, every background
+// worker is healthy and the service is not shutting down. It answers 503
+// otherwise, with the same body.
+func NewReadyz(log *slog.Logger, sc storageChecker, probe *Probe) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.health.readyz"
+
+		log := mwLogger.FromContext(r.Context(), log).With(slog.String("op", op))
+
+		ctx, cancel := context.WithTimeout(r.Context(), probe.Timeout)
+		defer cancel()
+
+		ready := !probe.shuttingDown.Load()
+		report := Readiness{
+			ShuttingDown: !ready,
+			Database:     "ok",
+			Workers:      make([]WorkerHealth, 0, len(probe.workers)),
+		}
+
+		err := sc.Ping(ctx)
+		if err == nil {
+			report.Migrations, err = sc.MigrationState(ctx)
+		}
+		if err != nil {
+			log.Warn("storage is not ready", slog.Any("error", err))
+			ready = false
+			report.Database = "unavailable"
+			report.Error = err.Error()
+		}
+		if report.Migrations != nil && report.Migrations.Pending > 0 {
+			ready = false
+		}
+
+		for _, worker := range probe.workers {
+			health := worker.Health()
+			ready = ready && health.Healthy
+			report.Workers = append(report.Workers, health)
+		}
+
+		status := http.StatusOK
+		report.Status = "ok"
+		if !ready {
+			status = http.StatusServiceUnavailable
+			report.Status = "unavailable"
+		}
+
+		w.WriteHeader(status)
+		render.JSON(w, r, report)
+	}
+}
@@ -1,20 +1,69 @@
 package handlers
 
 import (
+	"context"
 	"log/slog"
 	"net/http"
-	"strings"
+	"time"
 
 	"github.com/go-chi/render"
+	mwAuth "github.com/ten00m/golang-test-task/internal/http-server/middleware/auth"
+	mwLogger "github.com/ten00m/golang-test-task/internal/http-server/middleware/logger"
 	resp "github.com/ten00m/golang-test-task/internal/lib/api/response"
 )
 
+// Pull request statuses. A PR is created OPEN, or DRAFT without reviewers
+// until it is marked ready; CLOSED PRs can be reopened, MERGED ones are final.
+const (
+	PRStatusDraft  = "DRAFT"
+	PRStatusOpen   = "OPEN"
+	PRStatusMerged = "MERGED"
+	PRStatusClosed = "CLOSED"
+)
+
+// Review states of an assigned reviewer. Every reviewer starts as
+// ReviewPending.
+const (
+	ReviewPending          = "PENDING"
+	ReviewApproved         = "APPROVED"
+	ReviewChangesRequested = "CHANGES_REQUESTED"
+	ReviewCommented        = "COMMENTED"
+)
+
+// IsReviewState reports whether state is one of the Review* states.
+func IsReviewState(state string) bool {
+	switch state {
+	case ReviewPending, ReviewApproved, ReviewChangesRequested, ReviewCommented:
+		return true
+	}
+	return false
+}
+
+type Review struct {
+	ReviewerID string     `json:"reviewer_id"`
+	State      string     `json:"state"`
+	AssignedAt *time.Time `json:"assignedAt"`
+}
+
 type PullRequest struct {
 	ID                string   `json:"pull_request_id"`
 	Name              string   `json:"pull_request_name"`
 	AuthorID          string   `json:"author_id"`
 	Status            string   `json:"status"`
 	AssignedReviewers []string `json:"assigned_reviewers"`
+	// FallbackReviewers are the assigned reviewers that were picked from
+	// one of the fallback teams rather than the author's team.
+	FallbackReviewers []string `json:"fallback_reviewers,omitempty"`
+	// Reviews holds the review state of every assigned reviewer, in the
+	// order of AssignedReviewers.
+	Reviews []Review `json:"reviews"`
+
+	// Timestamps are nil for PRs created before they were recorded, and
+	// MergedAt/ClosedAt are nil unless the PR is MERGED/CLOSED.
+	CreatedAt *time.Time `json:"createdAt"`
+	UpdatedAt *time.Time `json:"updatedAt"`
+	MergedAt  *time.Time `json:"mergedAt"`
+	ClosedAt  *time.Time `json:"closedAt"`
 }
 
 type PullRequestShort struct {
@@ -22,22 +71,49 @@ type PullRequestShort struct {
 	Name     string `json:"pull_request_name"`
 	AuthorID string `json:"author_id"`
 	Status   string `json:"status"`
+	// ReviewState is the state of the review by the user the PRs were
+	// listed for.
+	ReviewState string `json:"review_state,omitempty"`
+	// AssignedAt is when the user the PRs were listed for was assigned.
+	AssignedAt *time.Time `json:"assignedAt"`
+
+	CreatedAt *time.Time `json:"createdAt"`
+	UpdatedAt *time.Time `json:"updatedAt"`
+	MergedAt  *time.Time `json:"mergedAt"`
+	ClosedAt  *time.Time `json:"closedAt"`
+}
+
+// Orders of the PRs returned for a reviewer, by PR creation time.
+const (
+	SortOldest = "oldest"
+	SortNewest = "newest"
+)
+
+// ReviewFilter narrows down the PRs returned for a reviewer.
+type ReviewFilter struct {
+	// States keeps only the PRs where the reviewer's review is in one of
+	// these states; empty keeps all.
+	States []string
+	// Sort is SortOldest, SortNewest or empty for no particular order.
+	Sort string
 }
 
 type pullRequestCreator interface {
-	CreatePullRequest(prID, prName, authorID string) (*PullRequest, error)
+	CreatePullRequest(ctx context.Context, actor, prID, prName, authorID string, draft bool) (*PullRequest, error)
 }
 
 func NewPullRequestCreate(log *slog.Logger, prc pullRequestCreator) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		const op = "handlers.pullrequest.create"
 
-		log = log.With(slog.String("op", op))
+		log := mwLogger.FromContext(r.Context(), log).With(slog.String("op", op))
 
 		var req struct {
 			PullRequestID   string `json:"pull_request_id"`
 			PullRequestName string `json:"pull_request_name"`
 			AuthorID        string `json:"author_id"`
+			// Draft creates the PR without reviewers; see NewPullRequestReady.
+			Draft bool `json:"draft"`
 		}
 
 		err := render.DecodeJSON(r.Body, &req)
@@ -48,24 +124,13 @@ func NewPullRequestCreate(log *slog.Logger, prc pullRequestCreator) http.Handler
 			return
 		}
 
-		pr, err := prc.CreatePullRequest(req.PullRequestID, req.PullRequestName, req.AuthorID)
+		pr, err := prc.CreatePullRequest(r.Context(), mwAuth.Actor(r.Context()), req.PullRequestID, req.PullRequestName, req.AuthorID, req.Draft)
 		if err != nil {
 			log.Error("Failed to create PR", slog.Any("error", err))
 
-			if strings.Contains(err.Error(), "PR already exists") {
-				w.WriteHeader(http.StatusConflict)
-				render.JSON(w, r, resp.ErrorResponse("PR id already exists", resp.CodePRExists))
-				return
-			}
-
-			if strings.Contains(err.Error(), "not found") {
-				w.WriteHeader(http.StatusNotFound)
-				render.JSON(w, r, resp.ErrorResponse("Author or team not found", resp.CodeNotFound))
-				return
-			}
-
-			w.WriteHeader(http.StatusInternalServerError)
-			render.JSON(w, r, resp.ErrorResponse("Internal error", resp.StatusError))
+			status, body := resp.FromError(err)
+			w.WriteHeader(status)
+			render.JSON(w, r, body)
 			return
 		}
 
@@ -77,17 +142,19 @@ func NewPullRequestCreate(log *slog.Logger, prc pullRequestCreator) http.Handler
 }
 
 type pullRequestMerger interface {
-	MergePullRequest(prID string) (*PullRequest, error)
+	MergePullRequest(ctx context.Context, actor, prID string, force bool) (pr *PullRequest, merged bool, err error)
 }
 
 func NewPullRequestMerge(log *slog.Logger, prm pullRequestMerger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		const op = "handlers.pullrequest.merge"
 
-		log = log.With(slog.String("op", op))
+		log := mwLogger.FromContext(r.Context(), log).With(slog.String("op", op))
 
 		var req struct {
 			PullRequestID string `json:"pull_request_id"`
+			// Force skips the team merge policy; admins only.
+			Force bool `json:"force"`
 		}
 
 		err := render.DecodeJSON(r.Body, &req)
@@ -98,22 +165,28 @@ func NewPullRequestMerge(log *slog.Logger, prm pullRequestMerger) http.HandlerFu
 			return
 		}
 
-		pr, err := prm.MergePullRequest(req.PullRequestID)
+		if req.Force && !mwAuth.IsAdmin(r.Context()) {
+			log.Warn("non-admin attempted a forced merge", slog.String("pr_id", req.PullRequestID))
+			w.WriteHeader(http.StatusForbidden)
+			render.JSON(w, r, resp.ErrorResponse("force merge requires admin token", resp.CodeForbidden))
+			return
+		}
+
+		pr, merged, err := prm.MergePullRequest(r.Context(), mwAuth.Actor(r.Context()), req.PullRequestID, req.Force)
 		if err != nil {
 			log.Error("Failed to merge PR", slog.Any("error", err))
 
-			if strings.Contains(err.Error(), "not found") {
-				w.WriteHeader(http.StatusNotFound)
-				render.JSON(w, r, resp.ErrorResponse("PR not found", resp.CodeNotFound))
-				return
-			}
-
-			w.WriteHeader(http.StatusInternalServerError)
-			render.JSON(w, r, resp.ErrorResponse("Internal error", resp.StatusError))
+			status, body := resp.FromError(err)
+			w.WriteHeader(status)
+			render.JSON(w, r, body)
 			return
 		}
 
-		log.Info("PR merged successfully", slog.String("pr_id", req.PullRequestID))
+		if merged {
+			log.Info("PR merged successfully", slog.String("pr_id", req.PullRequestID), slog.Bool("force", req.Force))
+		} else {
+			log.Info("PR was already merged", slog.String("pr_id", req.PullRequestID))
+		}
 
 		w.WriteHeader(http.StatusOK)
 		render.JSON(w, r, map[string]interface{}{"pr": pr})
@@ -121,15 +194,15 @@ func NewPullRequestMerge(log *slog.Logger, prm pullRequestMerger) http.HandlerFu
 }
 
 type pullRequestReassigner interface {
-	ReassignReviewer(prID, oldReviewerID string) (string, error)
-	GetPullRequest(prID string) (*PullRequest, error)
+	ReassignReviewer(ctx context.Context, actor, prID, oldReviewerID string) (string, error)
+	GetPullRequest(ctx context.Context, prID string) (*PullRequest, error)
 }
 
 func NewPullRequestReassign(log *slog.Logger, prr pullRequestReassigner) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		const op = "handlers.pullrequest.reassign"
 
-		log = log.With(slog.String("op", op))
+		log := mwLogger.FromContext(r.Context(), log).With(slog.String("op", op))
 
 		var req struct {
 			PullRequestID string `json:"pull_request_id"`
@@ -144,41 +217,18 @@ func NewPullRequestReassign(log *slog.Logger, prr pullRequestReassigner) http.Ha
 			return
 		}
 
-		newReviewerID, err := prr.ReassignReviewer(req.PullRequestID, req.OldUserID)
+		newReviewerID, err := prr.ReassignReviewer(r.Context(), mwAuth.Actor(r.Context()), req.PullRequestID, req.OldUserID)
 		if err != nil {
 			log.Error("Failed to reassign reviewer", slog.Any("error", err))
 
-			if strings.Contains(err.Error(), "not found") {
-				w.WriteHeader(http.StatusNotFound)
-				render.JSON(w, r, resp.ErrorResponse("PR or user not found", resp.CodeNotFound))
-				return
-			}
-
-			if strings.Contains(err.Error(), "cannot reassign on merged PR") {
-				w.WriteHeader(http.StatusConflict)
-				render.JSON(w, r, resp.ErrorResponse("cannot reassign on merged PR", resp.CodePRMerged))
-				return
-			}
-
-			if strings.Contains(err.Error(), "reviewer is not assigned") {
-				w.WriteHeader(http.StatusConflict)
-				render.JSON(w, r, resp.ErrorResponse("reviewer is not assigned to this PR", resp.CodeNotAssigned))
-				return
-			}
-
-			if strings.Contains(err.Error(), "no active replacement candidate") {
-				w.WriteHeader(http.StatusConflict)
-				render.JSON(w, r, resp.ErrorResponse("no active replacement candidate in team", resp.CodeNoCandidate))
-				return
-			}
-
-			w.WriteHeader(http.StatusInternalServerError)
-			render.JSON(w, r, resp.ErrorResponse("Internal error", resp.StatusError))
+			status, body := resp.FromError(err)
+			w.WriteHeader(status)
+			render.JSON(w, r, body)
 			return
 		}
 
 		// Get updated PR
-		pr, err := prr.GetPullRequest(req.PullRequestID)
+		pr, err := prr.GetPullRequest(r.Context(), req.PullRequestID)
 		if err != nil {
 			log.Error("Failed to get updated PR", slog.Any("error", err))
 			w.WriteHeader(http.StatusInternalServerError)
@@ -198,3 +248,108 @@ func NewPullRequestReassign(log *slog.Logger, prr pullRequestReassigner) http.Ha
 		})
 	}
 }
+
+type pullRequestReviewer interface {
+	SubmitReview(ctx context.Context, actor, prID, reviewerID, state string) (*PullRequest, error)
+}
+
+func NewPullRequestReview(log *slog.Logger, prr pullRequestReviewer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.pullrequest.review"
+
+		log := mwLogger.FromContext(r.Context(), log).With(slog.String("op", op))
+
+		var req struct {
+			PullRequestID string `json:"pull_request_id"`
+			ReviewerID    string `json:"reviewer_id"`
+			State         string `json:"state"`
+		}
+
+		err := render.DecodeJSON(r.Body, &req)
+		if err != nil {
+			log.Error("Failed to decode request body", slog.Any("error", err))
+			w.WriteHeader(http.StatusBadRequest)
+			render.JSON(w, r, resp.ErrorResponse("Failed to decode request", resp.StatusError))
+			return
+		}
+
+		pr, err := prr.SubmitReview(r.Context(), mwAuth.Actor(r.Context()), req.PullRequestID, req.ReviewerID, req.State)
+		if err != nil {
+			log.Error("Failed to submit review", slog.Any("error", err))
+
+			status, body := resp.FromError(err)
+			w.WriteHeader(status)
+			render.JSON(w, r, body)
+			return
+		}
+
+		log.Info("Review submitted successfully",
+			slog.String("pr_id", req.PullRequestID),
+			slog.String("reviewer", req.ReviewerID),
+			slog.String("state", req.State))
+
+		w.WriteHeader(http.StatusOK)
+		render.JSON(w, r, map[string]interface{}{"pr": pr})
+	}
+}
+
+type pullRequestReadier interface {
+	ReadyPullRequest(ctx context.Context, actor, prID string) (*PullRequest, error)
+}
+
+// NewPullRequestReady marks a draft PR ready for review, which assigns its
+// reviewers.
+func NewPullRequestReady(log *slog.Logger, prr pullRequestReadier) http.HandlerFunc {
+	return newPullRequestTransition(log, "handlers.pullrequest.ready", "marked ready", prr.ReadyPullRequest)
+}
+
+type pullRequestCloser interface {
+	ClosePullRequest(ctx context.Context, actor, prID string) (*PullRequest, error)
+}
+
+func NewPullRequestClose(log *slog.Logger, prc pullRequestCloser) http.HandlerFunc {
+	return newPullRequestTransition(log, "handlers.pullrequest.close", "closed", prc.ClosePullRequest)
+}
+
+type pullRequestReopener interface {
+	ReopenPullRequest(ctx context.Context, actor, prID string) (*PullRequest, error)
+}
+
+func NewPullRequestReopen(log *slog.Logger, pro pullRequestReopener) http.HandlerFunc {
+	return newPullRequestTransition(log, "handlers.pullrequest.reopen", "reopened", pro.ReopenPullRequest)
+}
+
+// newPullRequestTransition builds the handlers that take just a PR ID and
+// move the PR to another status.
+func newPullRequestTransition(log *slog.Logger, op, done string, transition func(ctx context.Context, actor, prID string) (*PullRequest, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := mwLogger.FromContext(r.Context(), log).With(slog.String("op", op))
+
+		var req struct {
+			PullRequestID string `json:"pull_request_id"`
+		}
+
+		err := render.DecodeJSON(r.Body, &req)
+		if err != nil {
+			log.Error("Failed to decode request body", slog.Any("error", err))
+			w.WriteHeader(http.StatusBadRequest)
+			render.JSON(w, r, resp.ErrorResponse("Failed to decode request", resp.StatusError))
+			return
+		}
+
+		pr, err := transition(r.Context(), mwAuth.Actor(r.Context()), req.PullRequestID)
+		if err != nil {
+			log.Error("Failed to update PR status", slog.Any("error", err))
+
+			status, body := resp.FromError(err)
+			w.WriteHeader(status)
+			render.JSON(w, r, body)
+			return
+		}
+
+		log.Info("PR "+done+" successfully", slog.String("pr_id", req.PullRequestID))
+
+		w.WriteHeader(http.StatusOK)
+		render.JSON(w, r, map[string]interface{}{"pr": pr})
+	}
+}
@@ -1,29 +1,60 @@
 package handlers
 
 import (
-	"database/sql"
-	"errors"
+	"context"
 	"log/slog"
 	"net/http"
 
 	"github.com/go-chi/render"
+	mwAuth "github.com/ten00m/golang-test-task/internal/http-server/middleware/auth"
+	mwLogger "github.com/ten00m/golang-test-task/internal/http-server/middleware/logger"
 	resp "github.com/ten00m/golang-test-task/internal/lib/api/response"
 )
 
 type Team struct {
-	Name    string `json:"team_name"`
-	Members []User `json:"members"`
+	Name     string       `json:"team_name"`
+	Members  []User       `json:"members"`
+	Settings TeamSettings `json:"settings"`
+}
+
+type TeamSettings struct {
+	// ReviewerStrategy is one of the reviewer.Strategy* names; empty means
+	// the strategy from the service configuration.
+	ReviewerStrategy string `json:"reviewer_strategy,omitempty"`
+	// ReviewersPerPR is how many reviewers a new PR gets; 0 means 2.
+	ReviewersPerPR int `json:"reviewers_per_pr"`
+	// MinReviewers makes PR creation fail when fewer active candidates
+	// than this are available.
+	MinReviewers int `json:"min_reviewers"`
+	// FallbackTeams are asked for reviewers, in order, when the team itself
+	// cannot supply enough active candidates.
+	FallbackTeams []string `json:"fallback_teams,omitempty"`
+	// MergePolicy is checked before PRs authored by team members are merged.
+	MergePolicy MergePolicy `json:"merge_policy"`
+}
+
+// MergePolicy lists the conditions a PR must meet to be merged. The zero
+// value allows every merge.
+type MergePolicy struct {
+	// RequiredApprovals is the minimum number of APPROVED reviews.
+	RequiredApprovals int `json:"required_approvals"`
+	// BlockOnChangesRequested forbids merging while any review is
+	// CHANGES_REQUESTED.
+	BlockOnChangesRequested bool `json:"block_on_changes_requested"`
+	// RequireAllReviewers forbids merging while any review is PENDING.
+	RequireAllReviewers bool `json:"require_all_reviewers"`
 }
 
 type teamAdder interface {
-	AddTeam(team Team) error
+	AddTeam(ctx context.Context, actor string, team Team) error
+	GetTeam(ctx context.Context, teamName string) (Team, error)
 }
 
 func NewAddTeam(log *slog.Logger, ta teamAdder) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		const op = "router.teams.add"
 
-		log = log.With(slog.String("op", op))
+		log := mwLogger.FromContext(r.Context(), log).With(slog.String("op", op))
 
 		var req Team
 
@@ -39,31 +70,43 @@ func NewAddTeam(log *slog.Logger, ta teamAdder) http.HandlerFunc {
 
 		log.Info("Request decoded successfully")
 
-		err = ta.AddTeam(req)
+		err = ta.AddTeam(r.Context(), mwAuth.Actor(r.Context()), req)
 		if err != nil {
 			log.Error("Failed to add team: %s", slog.Any("%s", err))
 
-			w.WriteHeader(http.StatusBadRequest)
+			status, body := resp.FromError(err)
+			w.WriteHeader(status)
+			render.JSON(w, r, body)
+			return
+		}
+
+		// Render what was stored, with defaults filled in for the settings
+		// the request left out.
+		team, err := ta.GetTeam(r.Context(), req.Name)
+		if err != nil {
+			log.Error("Failed to get created team", slog.Any("error", err))
 
-			render.JSON(w, r, resp.ErrorResponse("team_name already exists", resp.CodeTeamExists))
+			status, body := resp.FromError(err)
+			w.WriteHeader(status)
+			render.JSON(w, r, body)
 			return
 		}
 
 		w.WriteHeader(http.StatusCreated)
-		render.JSON(w, r, req)
+		render.JSON(w, r, team)
 	}
 
 }
 
 type teamGetter interface {
-	GetTeam(teamName string) (Team, error)
+	GetTeam(ctx context.Context, teamName string) (Team, error)
 }
 
 func NewGetTeam(log *slog.Logger, tg teamGetter) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		const op = "router.teams.get"
 
-		log = log.With(slog.String("op", op))
+		log := mwLogger.FromContext(r.Context(), log).With(slog.String("op", op))
 
 		q := r.URL.Query()
 		teamName := q.Get("team_name")
@@ -74,18 +117,13 @@ func NewGetTeam(log *slog.Logger, tg teamGetter) http.HandlerFunc {
 			return
 		}
 
-		team, err := tg.GetTeam(teamName)
+		team, err := tg.GetTeam(r.Context(), teamName)
 		if err != nil {
-			if errors.Is(err, sql.ErrNoRows) {
-				log.Info("team not found", slog.String("team_name", teamName))
-				w.WriteHeader(http.StatusNotFound)
-				render.JSON(w, r, resp.ErrorResponse("team not found", resp.CodeNotFound))
-				return
-			}
-
 			log.Error("failed to get team", slog.Any("err", err))
-			w.WriteHeader(http.StatusInternalServerError)
-			render.JSON(w, r, resp.ErrorResponse("internal error", resp.CodeNotFound))
+
+			status, body := resp.FromError(err)
+			w.WriteHeader(status)
+			render.JSON(w, r, body)
 			return
 		}
 
@@ -93,3 +131,323 @@ func NewGetTeam(log *slog.Logger, tg teamGetter) http.HandlerFunc {
 		render.JSON(w, r, team)
 	}
 }
+
+type teamSettingsGetter interface {
+	GetTeamSettings(ctx context.Context, teamName string) (*TeamSettings, error)
+}
+
+func NewGetTeamSettings(log *slog.Logger, tsg teamSettingsGetter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "router.teams.getSettings"
+
+		log := mwLogger.FromContext(r.Context(), log).With(slog.String("op", op))
+
+		teamName := r.URL.Query().Get("team_name")
+		if teamName == "" {
+			log.Warn("missing team_name query param")
+			w.WriteHeader(http.StatusBadRequest)
+			render.JSON(w, r, resp.ErrorResponse("team_name query param required", resp.CodeNotFound))
+			return
+		}
+
+		settings, err := tsg.GetTeamSettings(r.Context(), teamName)
+		if err != nil {
+			log.Error("failed to get team settings", slog.Any("err", err))
+
+			status, body := resp.FromError(err)
+			w.WriteHeader(status)
+			render.JSON(w, r, body)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		render.JSON(w, r, map[string]interface{}{
+			"team_name": teamName,
+			"settings":  settings,
+		})
+	}
+}
+
+type teamSettingsUpdater interface {
+	UpdateTeamSettings(ctx context.Context, actor, teamName string, settings TeamSettings) (*TeamSettings, error)
+}
+
+// NewUpdateTeamSettings replaces all settings of a team; omitted fields fall
+// back to their defaults.
+func NewUpdateTeamSettings(log *slog.Logger, tsu teamSettingsUpdater) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "router.teams.updateSettings"
+
+		log := mwLogger.FromContext(r.Context(), log).With(slog.String("op", op))
+
+		var req struct {
+			TeamName string       `json:"team_name"`
+			Settings TeamSettings `json:"settings"`
+		}
+
+		err := render.DecodeJSON(r.Body, &req)
+		if err != nil {
+			log.Error("Failed to decode request body", slog.Any("error", err))
+			w.WriteHeader(http.StatusBadRequest)
+			render.JSON(w, r, resp.ErrorResponse("Failed to decode request", resp.StatusError))
+			return
+		}
+
+		settings, err := tsu.UpdateTeamSettings(r.Context(), mwAuth.Actor(r.Context()), req.TeamName, req.Settings)
+		if err != nil {
+			log.Error("Failed to update team settings", slog.Any("error", err))
+
+			status, body := resp.FromError(err)
+			w.WriteHeader(status)
+			render.JSON(w, r, body)
+			return
+		}
+
+		log.Info("Team settings updated successfully", slog.String("team_name", req.TeamName))
+
+		w.WriteHeader(http.StatusOK)
+		render.JSON(w, r, map[string]interface{}{
+			"team_name": req.TeamName,
+			"settings":  settings,
+		})
+	}
+}
+
+// TeamDeactivation summarizes a bulk deactivation of team members.
+type TeamDeactivation struct {
+	TeamName string `json:"team_name"`
+	// Deactivated lists the users that were active before.
+	Deactivated  []string        `json:"deactivated"`
+	Reassignment *ReassignReport `json:"reassignment"`
+}
+
+type teamUsersDeactivator interface {
+	DeactivateTeamUsers(ctx context.Context, actor, teamName string, userIDs []string, allExcept bool) (*TeamDeactivation, error)
+}
+
+// NewDeactivateTeamUsers deactivates the given members of a team, or all but
+// the given ones, and reassigns their open reviews.
+func NewDeactivateTeamUsers(log *slog.Logger, tud teamUsersDeactivator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "router.teams.deactivateUsers"
+
+		log := mwLogger.FromContext(r.Context(), log).With(slog.String("op", op))
+
+		var req struct {
+			TeamName  string   `json:"team_name"`
+			UserIDs   []string `json:"user_ids"`
+			AllExcept []string `json:"all_except"`
+		}
+
+		err := render.DecodeJSON(r.Body, &req)
+		if err != nil {
+			log.Error("Failed to decode request body", slog.Any("error", err))
+			w.WriteHeader(http.StatusBadRequest)
+			render.JSON(w, r, resp.ErrorResponse("Failed to decode request", resp.StatusError))
+			return
+		}
+
+		// An empty all_except list still means "everyone", so the lists are
+		// told apart by presence rather than length.
+		if (req.UserIDs == nil) == (req.AllExcept == nil) {
+			log.Warn("exactly one of user_ids and all_except is required")
+			w.WriteHeader(http.StatusBadRequest)
+			render.JSON(w, r, resp.ErrorResponse("exactly one of user_ids and all_except is required", resp.StatusError))
+			return
+		}
+
+		userIDs, allExcept := req.UserIDs, false
+		if req.AllExcept != nil {
+			userIDs, allExcept = req.AllExcept, true
+		}
+
+		result, err := tud.DeactivateTeamUsers(r.Context(), mwAuth.Actor(r.Context()), req.TeamName, userIDs, allExcept)
+		if err != nil {
+			log.Error("Failed to deactivate team users", slog.Any("error", err))
+
+			status, body := resp.FromError(err)
+			w.WriteHeader(status)
+			render.JSON(w, r, body)
+			return
+		}
+
+		log.Info("Team users deactivated",
+			slog.String("team_name", req.TeamName),
+			slog.Int("deactivated", len(result.Deactivated)),
+			slog.Int("reassigned", len(result.Reassignment.Reassigned)),
+			slog.Int("failed", len(result.Reassignment.Failed)),
+		)
+
+		w.WriteHeader(http.StatusOK)
+		render.JSON(w, r, result)
+	}
+}
+
+type teamMembersAdder interface {
+	AddTeamMembers(ctx context.Context, actor, teamName string, members []User) (Team, error)
+}
+
+// NewAddTeamMembers adds users to an existing team.
+func NewAddTeamMembers(log *slog.Logger, tma teamMembersAdder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "router.teams.addMembers"
+
+		log := mwLogger.FromContext(r.Context(), log).With(slog.String("op", op))
+
+		var req struct {
+			TeamName string `json:"team_name"`
+			Members  []User `json:"members"`
+		}
+
+		err := render.DecodeJSON(r.Body, &req)
+		if err != nil {
+			log.Error("Failed to decode request body", slog.Any("error", err))
+			w.WriteHeader(http.StatusBadRequest)
+			render.JSON(w, r, resp.ErrorResponse("Failed to decode request", resp.StatusError))
+			return
+		}
+
+		team, err := tma.AddTeamMembers(r.Context(), mwAuth.Actor(r.Context()), req.TeamName, req.Members)
+		if err != nil {
+			log.Error("Failed to add team members", slog.Any("error", err))
+
+			status, body := resp.FromError(err)
+			w.WriteHeader(status)
+			render.JSON(w, r, body)
+			return
+		}
+
+		log.Info("Team members added", slog.String("team_name", req.TeamName), slog.Int("count", len(req.Members)))
+
+		w.WriteHeader(http.StatusOK)
+		render.JSON(w, r, map[string]interface{}{"team": team})
+	}
+}
+
+type teamMembersRemover interface {
+	RemoveTeamMembers(ctx context.Context, actor, teamName string, userIDs []string) (Team, error)
+}
+
+// NewRemoveTeamMembers takes users out of a team. Users with open pull
+// requests are refused.
+func NewRemoveTeamMembers(log *slog.Logger, tmr teamMembersRemover) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "router.teams.removeMembers"
+
+		log := mwLogger.FromContext(r.Context(), log).With(slog.String("op", op))
+
+		var req struct {
+			TeamName string   `json:"team_name"`
+			UserIDs  []string `json:"user_ids"`
+		}
+
+		err := render.DecodeJSON(r.Body, &req)
+		if err != nil {
+			log.Error("Failed to decode request body", slog.Any("error", err))
+			w.WriteHeader(http.StatusBadRequest)
+			render.JSON(w, r, resp.ErrorResponse("Failed to decode request", resp.StatusError))
+			return
+		}
+
+		team, err := tmr.RemoveTeamMembers(r.Context(), mwAuth.Actor(r.Context()), req.TeamName, req.UserIDs)
+		if err != nil {
+			log.Error("Failed to remove team members", slog.Any("error", err))
+
+			status, body := resp.FromError(err)
+			w.WriteHeader(status)
+			render.JSON(w, r, body)
+			return
+		}
+
+		log.Info("Team members removed", slog.String("team_name", req.TeamName), slog.Int("count", len(req.UserIDs)))
+
+		w.WriteHeader(http.StatusOK)
+		render.JSON(w, r, map[string]interface{}{"team": team})
+	}
+}
+
+type teamRenamer interface {
+	RenameTeam(ctx context.Context, actor, teamName, newName string) (Team, error)
+}
+
+func NewRenameTeam(log *slog.Logger, tr teamRenamer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "router.teams.rename"
+
+		log := mwLogger.FromContext(r.Context(), log).With(slog.String("op", op))
+
+		var req struct {
+			TeamName string `json:"team_name"`
+			NewName  string `json:"new_name"`
+		}
+
+		err := render.DecodeJSON(r.Body, &req)
+		if err != nil {
+			log.Error("Failed to decode request body", slog.Any("error", err))
+			w.WriteHeader(http.StatusBadRequest)
+			render.JSON(w, r, resp.ErrorResponse("Failed to decode request", resp.StatusError))
+			return
+		}
+
+		if req.NewName == "" {
+			log.Warn("missing new_name")
+			w.WriteHeader(http.StatusBadRequest)
+			render.JSON(w, r, resp.ErrorResponse("new_name is required", resp.StatusError))
+			return
+		}
+
+		team, err := tr.RenameTeam(r.Context(), mwAuth.Actor(r.Context()), req.TeamName, req.NewName)
+		if err != nil {
+			log.Error("Failed to rename team", slog.Any("error", err))
+
+			status, body := resp.FromError(err)
+			w.WriteHeader(status)
+			render.JSON(w, r, body)
+			return
+		}
+
+		log.Info("Team renamed", slog.String("team_name", req.TeamName), slog.String("new_name", req.NewName))
+
+		w.WriteHeader(http.StatusOK)
+		render.JSON(w, r, map[string]interface{}{"team": team})
+	}
+}
+
+type teamDeleter interface {
+	DeleteTeam(ctx context.Context, actor, teamName string) error
+}
+
+// NewDeleteTeam deletes a team without members.
+func NewDeleteTeam(log *slog.Logger, td teamDeleter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "router.teams.delete"
+
+		log := mwLogger.FromContext(r.Context(), log).With(slog.String("op", op))
+
+		var req struct {
+			TeamName string `json:"team_name"`
+		}
+
+		err := render.DecodeJSON(r.Body, &req)
+		if err != nil {
+			log.Error("Failed to decode request body", slog.Any("error", err))
+			w.WriteHeader(http.StatusBadRequest)
+			render.JSON(w, r, resp.ErrorResponse("Failed to decode request", resp.StatusError))
+			return
+		}
+
+		if err := td.DeleteTeam(r.Context(), mwAuth.Actor(r.Context()), req.TeamName); err != nil {
+			log.Error("Failed to delete team", slog.Any("error", err))
+
+			status, body := resp.FromError(err)
+			w.WriteHeader(status)
+			render.JSON(w, r, body)
+			return
+		}
+
+		log.Info("Team deleted", slog.String("team_name", req.TeamName))
+
+		w.WriteHeader(http.StatusOK)
+		render.JSON(w, r, map[string]interface{}{"team_name": req.TeamName})
+	}
+}
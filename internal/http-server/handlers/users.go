@@ -1,11 +1,14 @@
 package handlers
 
 import (
+	"context"
 	"log/slog"
 	"net/http"
 	"strings"
 
 	"github.com/go-chi/render"
+	mwAuth "github.com/ten00m/golang-test-task/internal/http-server/middleware/auth"
+	mwLogger "github.com/ten00m/golang-test-task/internal/http-server/middleware/logger"
 	resp "github.com/ten00m/golang-test-task/internal/lib/api/response"
 )
 
@@ -14,17 +17,38 @@ type User struct {
 	Username string `json:"username"`
 	IsActive bool   `json:"is_active"`
 	TeamName string `json:"team_name,omitempty"`
+	// ReviewWeight is used by the weighted reviewer strategy; 0 means 1.
+	ReviewWeight int `json:"review_weight,omitempty"`
+	// MaxOpenReviews stops the user from being picked as a reviewer once
+	// they review that many OPEN PRs; 0 means the configured default.
+	MaxOpenReviews int `json:"max_open_reviews,omitempty"`
+}
+
+// ReassignReport tells what happened to the open reviews of deactivated
+// users.
+type ReassignReport struct {
+	Reassigned []Reassignment `json:"reassigned"`
+	// Failed lists the reviews nobody could take over; they stay with the
+	// deactivated user.
+	Failed []Reassignment `json:"failed"`
+}
+
+type Reassignment struct {
+	PRID          string `json:"pull_request_id"`
+	OldReviewerID string `json:"old_reviewer_id"`
+	NewReviewerID string `json:"new_reviewer_id,omitempty"`
+	Reason        string `json:"reason,omitempty"`
 }
 
 type userActivationSetter interface {
-	SetUserIsActive(userID string, isActive bool) (*User, error)
+	SetUserIsActive(ctx context.Context, actor, userID string, isActive bool) (*User, *ReassignReport, error)
 }
 
 func NewUsersSetIsActive(log *slog.Logger, uas userActivationSetter) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		const op = "handlers.users.setIsActive"
 
-		log = log.With(slog.String("op", op))
+		log := mwLogger.FromContext(r.Context(), log).With(slog.String("op", op))
 
 		var req struct {
 			UserID   string `json:"user_id"`
@@ -39,37 +63,89 @@ func NewUsersSetIsActive(log *slog.Logger, uas userActivationSetter) http.Handle
 			return
 		}
 
-		user, err := uas.SetUserIsActive(req.UserID, req.IsActive)
+		user, report, err := uas.SetUserIsActive(r.Context(), mwAuth.Actor(r.Context()), req.UserID, req.IsActive)
 		if err != nil {
 			log.Error("Failed to set user is_active", slog.Any("error", err))
 
-			if strings.Contains(err.Error(), "not found") {
-				w.WriteHeader(http.StatusNotFound)
-				render.JSON(w, r, resp.ErrorResponse("User not found", resp.CodeNotFound))
-				return
-			}
-
-			w.WriteHeader(http.StatusInternalServerError)
-			render.JSON(w, r, resp.ErrorResponse("Internal error", resp.StatusError))
+			status, body := resp.FromError(err)
+			w.WriteHeader(status)
+			render.JSON(w, r, body)
 			return
 		}
 
 		log.Info("User is_active updated successfully", slog.String("user_id", req.UserID))
 
+		body := map[string]interface{}{"user": user}
+		if report != nil {
+			log.Info("Open reviews reassigned",
+				slog.Int("reassigned", len(report.Reassigned)),
+				slog.Int("failed", len(report.Failed)),
+			)
+			body["reassignment"] = report
+		}
+
+		w.WriteHeader(http.StatusOK)
+		render.JSON(w, r, body)
+	}
+}
+
+type userTeamMover interface {
+	MoveUserToTeam(ctx context.Context, actor, userID, teamName string, handover bool) (*User, *ReassignReport, error)
+}
+
+// NewUsersMoveTeam moves a user to another team, optionally handing their
+// open reviews in the old team over to its remaining members.
+func NewUsersMoveTeam(log *slog.Logger, utm userTeamMover) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.users.moveTeam"
+
+		log := mwLogger.FromContext(r.Context(), log).With(slog.String("op", op))
+
+		var req struct {
+			UserID   string `json:"user_id"`
+			TeamName string `json:"team_name"`
+			Handover bool   `json:"handover"`
+		}
+
+		err := render.DecodeJSON(r.Body, &req)
+		if err != nil {
+			log.Error("Failed to decode request body", slog.Any("error", err))
+			w.WriteHeader(http.StatusBadRequest)
+			render.JSON(w, r, resp.ErrorResponse("Failed to decode request", resp.StatusError))
+			return
+		}
+
+		user, report, err := utm.MoveUserToTeam(r.Context(), mwAuth.Actor(r.Context()), req.UserID, req.TeamName, req.Handover)
+		if err != nil {
+			log.Error("Failed to move user", slog.Any("error", err))
+
+			status, body := resp.FromError(err)
+			w.WriteHeader(status)
+			render.JSON(w, r, body)
+			return
+		}
+
+		log.Info("User moved", slog.String("user_id", req.UserID), slog.String("team_name", req.TeamName))
+
+		body := map[string]interface{}{"user": user}
+		if report != nil {
+			body["reassignment"] = report
+		}
+
 		w.WriteHeader(http.StatusOK)
-		render.JSON(w, r, map[string]interface{}{"user": user})
+		render.JSON(w, r, body)
 	}
 }
 
 type pullRequestsByReviewerGetter interface {
-	GetPullRequestsByReviewer(userID string) ([]PullRequestShort, error)
+	GetPullRequestsByReviewer(ctx context.Context, userID string, filter ReviewFilter) ([]PullRequestShort, error)
 }
 
 func NewUsersGetReview(log *slog.Logger, prg pullRequestsByReviewerGetter) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		const op = "handlers.users.getReview"
 
-		log = log.With(slog.String("op", op))
+		log := mwLogger.FromContext(r.Context(), log).With(slog.String("op", op))
 
 		q := r.URL.Query()
 		userID := q.Get("user_id")
@@ -80,11 +156,26 @@ func NewUsersGetReview(log *slog.Logger, prg pullRequestsByReviewerGetter) http.
 			return
 		}
 
-		prs, err := prg.GetPullRequestsByReviewer(userID)
+		// state may be repeated or comma separated:
+		// ?state=PENDING&state=CHANGES_REQUESTED or ?state=PENDING,CHANGES_REQUESTED
+		var filter ReviewFilter
+		for _, states := range q["state"] {
+			for _, state := range strings.Split(states, ",") {
+				if state = strings.TrimSpace(state); state != "" {
+					filter.States = append(filter.States, state)
+				}
+			}
+		}
+
+		filter.Sort = q.Get("sort")
+
+		prs, err := prg.GetPullRequestsByReviewer(r.Context(), userID, filter)
 		if err != nil {
 			log.Error("Failed to get pull requests for reviewer", slog.Any("error", err))
-			w.WriteHeader(http.StatusInternalServerError)
-			render.JSON(w, r, resp.ErrorResponse("Internal error", resp.StatusError))
+
+			status, body := resp.FromError(err)
+			w.WriteHeader(status)
+			render.JSON(w, r, body)
 			return
 		}
 
@@ -0,0 +1,34 @@
+package mwDeadline
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	mwLogger "github.com/ten00m/golang-test-task/internal/http-server/middleware/logger"
+)
+
+// New moves the write deadline of the server to timeout from now, for the
+// routes that run past http_server.timeout. A zero timeout removes the
+// deadline.
+func New(log *slog.Logger, timeout time.Duration) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		log := log.With(
+			slog.String("component", "middleware/deadline"),
+		)
+
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			var deadline time.Time
+			if timeout > 0 {
+				deadline = time.Now().Add(timeout)
+			}
+			if err := http.NewResponseController(w).SetWriteDeadline(deadline); err != nil {
+				mwLogger.FromContext(r.Context(), log).Warn("failed to extend write deadline", slog.Any("error", err))
+			}
+
+			next.ServeHTTP(w, r)
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
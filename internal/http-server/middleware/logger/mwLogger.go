@@ -1,13 +1,17 @@
 package mwLogger
 
 import (
+	"context"
 	"log/slog"
 	"net/http"
 	"time"
 
 	"github.com/go-chi/chi/v5/middleware"
+	"go.opentelemetry.io/otel/trace"
 )
 
+type attrsKey struct{}
+
 func New(log *slog.Logger) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		log := log.With(
@@ -17,13 +21,25 @@ func New(log *slog.Logger) func(next http.Handler) http.Handler {
 		log.Info("logger middleware enabled")
 
 		fn := func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+
+			// The request and trace IDs go on every record logged for the
+			// request, see FromContext.
+			attrs := []any{slog.String("request_id", middleware.GetReqID(ctx))}
+			if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+				attrs = append(attrs,
+					slog.String("trace_id", sc.TraceID().String()),
+					slog.String("span_id", sc.SpanID().String()),
+				)
+			}
+			r = r.WithContext(context.WithValue(ctx, attrsKey{}, attrs))
+
 			entry := log.With(
 				slog.String("method", r.Method),
 				slog.String("path", r.URL.Path),
 				slog.String("remote_addr", r.RemoteAddr),
 				slog.String("user_agent", r.UserAgent()),
-				slog.String("request_id", middleware.GetReqID(r.Context())),
-			)
+			).With(attrs...)
 			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
 
 			t1 := time.Now()
@@ -41,3 +57,13 @@ func New(log *slog.Logger) func(next http.Handler) http.Handler {
 		return http.HandlerFunc(fn)
 	}
 }
+
+// FromContext returns log with the request and trace IDs of the request
+// whose context is ctx, or log itself outside of New.
+func FromContext(ctx context.Context, log *slog.Logger) *slog.Logger {
+	attrs, _ := ctx.Value(attrsKey{}).([]any)
+	if len(attrs) == 0 {
+		return log
+	}
+	return log.With(attrs...)
+}
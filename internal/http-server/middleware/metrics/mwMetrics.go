@@ -0,0 +1,46 @@
+package mwMetrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/ten00m/golang-test-task/internal/metrics"
+)
+
+// New records the count and latency of requests. Requests are labelled with
+// the chi route pattern rather than the path, so IDs in paths or unknown
+// URLs do not blow up the number of series. It reads the status from the
+// WrapResponseWriter of mwLogger, which runs before it.
+func New(m *metrics.Metrics) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			ww, ok := w.(middleware.WrapResponseWriter)
+			if !ok {
+				ww = middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			}
+
+			t1 := time.Now()
+			defer func() {
+				route := chi.RouteContext(r.Context()).RoutePattern()
+				if route == "" {
+					route = "unmatched"
+				}
+				status := ww.Status()
+				if status == 0 {
+					status = http.StatusOK
+				}
+
+				labels := []string{r.Method, route, strconv.Itoa(status)}
+				m.HTTPRequests.WithLabelValues(labels...).Inc()
+				m.HTTPDuration.WithLabelValues(labels...).Observe(time.Since(t1).Seconds())
+			}()
+
+			next.ServeHTTP(ww, r)
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
@@ -0,0 +1,61 @@
+package response
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/ten00m/golang-test-task/internal/domain"
+)
+
+var domainErrors = []struct {
+	err    error
+	status int
+	code   string
+}{
+	{domain.ErrTeamExists, http.StatusBadRequest, CodeTeamExists},
+	{domain.ErrTeamNotEmpty, http.StatusConflict, CodeTeamNotEmpty},
+	{domain.ErrTeamInUse, http.StatusConflict, CodeTeamInUse},
+	{domain.ErrUserInOtherTeam, http.StatusConflict, CodeUserInOtherTeam},
+	{domain.ErrUserHasOpenPRs, http.StatusConflict, CodeUserHasOpenPRs},
+	{domain.ErrUnknownStrategy, http.StatusBadRequest, CodeInvalidSettings},
+	{domain.ErrInvalidSettings, http.StatusBadRequest, CodeInvalidSettings},
+	{domain.ErrUsernameTaken, http.StatusConflict, CodeUsernameTaken},
+	{domain.ErrPRExists, http.StatusConflict, CodePRExists},
+	{domain.ErrPRMerged, http.StatusConflict, CodePRMerged},
+	{domain.ErrPRIsMerged, http.StatusConflict, CodePRMerged},
+	{domain.ErrPRDraft, http.StatusConflict, CodePRDraft},
+	{domain.ErrPRClosed, http.StatusConflict, CodePRClosed},
+	{domain.ErrNotAssigned, http.StatusConflict, CodeNotAssigned},
+	{domain.ErrNoCandidate, http.StatusConflict, CodeNoCandidate},
+	{domain.ErrNotEnoughReviewers, http.StatusConflict, CodeNotEnoughReviewers},
+	{domain.ErrInvalidReviewState, http.StatusBadRequest, CodeInvalidReviewState},
+	{domain.ErrInvalidSort, http.StatusBadRequest, CodeInvalidSort},
+	{domain.ErrReviewMerged, http.StatusConflict, CodePRMerged},
+	{domain.ErrMergeBlocked, http.StatusConflict, CodeMergeBlocked},
+	{domain.ErrTeamNotFound, http.StatusNotFound, CodeNotFound},
+	{domain.ErrUserNotFound, http.StatusNotFound, CodeNotFound},
+	{domain.ErrAuthorNotFound, http.StatusNotFound, CodeNotFound},
+	{domain.ErrReviewerNotFound, http.StatusNotFound, CodeNotFound},
+	{domain.ErrPRNotFound, http.StatusNotFound, CodeNotFound},
+	{domain.ErrInvalidAbsence, http.StatusBadRequest, CodeInvalidAbsence},
+	{domain.ErrAbsenceNotFound, http.StatusNotFound, CodeNotFound},
+}
+
+// FromError maps an error returned by storage to the HTTP status and body
+// sent to the client. Errors that are not domain errors become a 500.
+func FromError(err error) (int, Response) {
+	for _, e := range domainErrors {
+		if errors.Is(err, e.err) {
+			body := ErrorResponse(e.err.Error(), e.code)
+
+			var blocked *domain.MergeBlockedError
+			if errors.As(err, &blocked) {
+				body.Error.Details = blocked.Unmet
+			}
+
+			return e.status, body
+		}
+	}
+
+	return http.StatusInternalServerError, ErrorResponse("Internal error", StatusError)
+}
@@ -8,18 +8,36 @@ type Response struct {
 type Error struct {
 	Code    string `json:"code"`
 	Message string `json:"message"`
+	// Details lists what exactly went wrong, e.g. the unmet merge policy
+	// conditions for MERGE_BLOCKED.
+	Details []string `json:"details,omitempty"`
 }
 
 const (
 	StatusOK    = "OK"
 	StatusError = "ERROR"
 
-	CodeTeamExists  = "TEAM_EXISTS"
-	CodePRExists    = "PR_EXISTS"
-	CodePRMerged    = "PR_MERGED"
-	CodeNotAssigned = "NOT_ASSIGNED"
-	CodeNoCandidate = "NO_CANDIDATE"
-	CodeNotFound    = "NOT_FOUND"
+	CodeTeamExists         = "TEAM_EXISTS"
+	CodeTeamNotEmpty       = "TEAM_NOT_EMPTY"
+	CodeTeamInUse          = "TEAM_IN_USE"
+	CodeUserInOtherTeam    = "USER_IN_OTHER_TEAM"
+	CodeUserHasOpenPRs     = "USER_HAS_OPEN_PRS"
+	CodeInvalidSettings    = "INVALID_SETTINGS"
+	CodeUsernameTaken      = "USERNAME_TAKEN"
+	CodePRExists           = "PR_EXISTS"
+	CodePRMerged           = "PR_MERGED"
+	CodePRDraft            = "PR_DRAFT"
+	CodePRClosed           = "PR_CLOSED"
+	CodeNotAssigned        = "NOT_ASSIGNED"
+	CodeNoCandidate        = "NO_CANDIDATE"
+	CodeNotEnoughReviewers = "NOT_ENOUGH_REVIEWERS"
+	CodeInvalidReviewState = "INVALID_REVIEW_STATE"
+	CodeInvalidSort        = "INVALID_SORT"
+	CodeInvalidQuery       = "INVALID_QUERY"
+	CodeInvalidAbsence     = "INVALID_ABSENCE"
+	CodeMergeBlocked       = "MERGE_BLOCKED"
+	CodeForbidden          = "FORBIDDEN"
+	CodeNotFound           = "NOT_FOUND"
 )
 
 func OK() Response {
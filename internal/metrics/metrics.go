@@ -0,0 +1,133 @@
+// Package metrics exposes the Prometheus metrics of the service: HTTP
+// traffic, the database pool and domain events recorded by Storage.
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "pr_reviewer"
+
+// Sources of reassignments, used as the source label.
+const (
+	SourceManual       = "manual"
+	SourceDeactivation = "deactivation"
+	SourceMove         = "move"
+	SourceAbsence      = "absence"
+)
+
+type Metrics struct {
+	registry *prometheus.Registry
+
+	HTTPRequests *prometheus.CounterVec
+	HTTPDuration *prometheus.HistogramVec
+
+	PRsCreated    prometheus.Counter
+	PRsMerged     prometheus.Counter
+	Reassignments *prometheus.CounterVec
+	NoCandidate   *prometheus.CounterVec
+}
+
+type openReviewsCounter interface {
+	CountOpenReviewsByTeam(ctx context.Context) (map[string]int, error)
+}
+
+// New registers the metrics of the service, the Go runtime and, when s is
+// backed by a SQL database, its connection pool. The open reviews gauge is
+// read from s on every scrape.
+func New(log *slog.Logger, s openReviewsCounter) *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		HTTPRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests by method, route and status.",
+		}, []string{"method", "route", "status"}),
+		HTTPDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "HTTP request latency by method, route and status.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+		PRsCreated: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "pull_requests_created_total",
+			Help:      "Pull requests created.",
+		}),
+		PRsMerged: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "pull_requests_merged_total",
+			Help:      "Pull requests merged.",
+		}),
+		Reassignments: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "reviewer_reassignments_total",
+			Help:      "Reviews moved to another reviewer, by what triggered the move.",
+		}, []string{"source"}),
+		NoCandidate: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "no_candidate_total",
+			Help:      "Reassignments that found no replacement reviewer, by what triggered them.",
+		}, []string{"source"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.HTTPRequests,
+		m.HTTPDuration,
+		m.PRsCreated,
+		m.PRsMerged,
+		m.Reassignments,
+		m.NoCandidate,
+		&openReviewsCollector{
+			log:     log.With(slog.String("component", "metrics")),
+			counter: s,
+			desc: prometheus.NewDesc(
+				prometheus.BuildFQName(namespace, "", "open_reviews"),
+				"Reviews assigned on OPEN pull requests, by team of the reviewer.",
+				[]string{"team"}, nil,
+			),
+		},
+	)
+
+	if db, ok := s.(interface{ GetConnection() *sql.DB }); ok {
+		m.registry.MustRegister(collectors.NewDBStatsCollector(db.GetConnection(), "main"))
+	}
+
+	return m
+}
+
+// Handler serves the registered metrics in the Prometheus text format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+type openReviewsCollector struct {
+	log     *slog.Logger
+	counter openReviewsCounter
+	desc    *prometheus.Desc
+}
+
+func (c *openReviewsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *openReviewsCollector) Collect(ch chan<- prometheus.Metric) {
+	counts, err := c.counter.CountOpenReviewsByTeam(context.Background())
+	if err != nil {
+		c.log.Error("failed to count open reviews", slog.Any("error", err))
+		ch <- prometheus.NewInvalidMetric(c.desc, err)
+		return
+	}
+	for team, n := range counts {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(n), team)
+	}
+}
@@ -0,0 +1,84 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+
+	"github.com/ten00m/golang-test-task/internal/domain"
+	"github.com/ten00m/golang-test-task/internal/http-server/handlers"
+	"github.com/ten00m/golang-test-task/internal/storage"
+)
+
+// instrumented counts the domain events of the Storage it wraps. Methods it
+// does not override are passed through.
+type instrumented struct {
+	storage.Storage
+	m *Metrics
+}
+
+// Instrument wraps s so that its pull request and reassignment events are
+// counted by m.
+func (m *Metrics) Instrument(s storage.Storage) storage.Storage {
+	return &instrumented{Storage: s, m: m}
+}
+
+func (s *instrumented) report(source string, report *handlers.ReassignReport) {
+	if report == nil {
+		return
+	}
+	s.m.Reassignments.WithLabelValues(source).Add(float64(len(report.Reassigned)))
+	s.m.NoCandidate.WithLabelValues(source).Add(float64(len(report.Failed)))
+}
+
+func (s *instrumented) CreatePullRequest(ctx context.Context, actor, prID, prName, authorID string, draft bool) (*handlers.PullRequest, error) {
+	pr, err := s.Storage.CreatePullRequest(ctx, actor, prID, prName, authorID, draft)
+	if err == nil {
+		s.m.PRsCreated.Inc()
+	}
+	return pr, err
+}
+
+func (s *instrumented) MergePullRequest(ctx context.Context, actor, prID string, force bool) (*handlers.PullRequest, bool, error) {
+	pr, merged, err := s.Storage.MergePullRequest(ctx, actor, prID, force)
+	if merged {
+		s.m.PRsMerged.Inc()
+	}
+	return pr, merged, err
+}
+
+func (s *instrumented) ReassignReviewer(ctx context.Context, actor, prID, oldReviewerID string) (string, error) {
+	newReviewerID, err := s.Storage.ReassignReviewer(ctx, actor, prID, oldReviewerID)
+	switch {
+	case err == nil:
+		s.m.Reassignments.WithLabelValues(SourceManual).Inc()
+	case errors.Is(err, domain.ErrNoCandidate):
+		s.m.NoCandidate.WithLabelValues(SourceManual).Inc()
+	}
+	return newReviewerID, err
+}
+
+func (s *instrumented) SetUserIsActive(ctx context.Context, actor, userID string, isActive bool) (*handlers.User, *handlers.ReassignReport, error) {
+	user, report, err := s.Storage.SetUserIsActive(ctx, actor, userID, isActive)
+	s.report(SourceDeactivation, report)
+	return user, report, err
+}
+
+func (s *instrumented) DeactivateTeamUsers(ctx context.Context, actor, teamName string, userIDs []string, allExcept bool) (*handlers.TeamDeactivation, error) {
+	result, err := s.Storage.DeactivateTeamUsers(ctx, actor, teamName, userIDs, allExcept)
+	if result != nil {
+		s.report(SourceDeactivation, result.Reassignment)
+	}
+	return result, err
+}
+
+func (s *instrumented) MoveUserToTeam(ctx context.Context, actor, userID, teamName string, handover bool) (*handlers.User, *handlers.ReassignReport, error) {
+	user, report, err := s.Storage.MoveUserToTeam(ctx, actor, userID, teamName, handover)
+	s.report(SourceMove, report)
+	return user, report, err
+}
+
+func (s *instrumented) ReassignAbsentReviewers(ctx context.Context, actor string) (*handlers.ReassignReport, error) {
+	report, err := s.Storage.ReassignAbsentReviewers(ctx, actor)
+	s.report(SourceAbsence, report)
+	return report, err
+}
@@ -0,0 +1,214 @@
+// Package reviewer decides which team members review a pull request.
+package reviewer
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"sort"
+	"sync"
+)
+
+const (
+	StrategyRandom      = "random"
+	StrategyRoundRobin  = "round_robin"
+	StrategyLeastLoaded = "least_loaded"
+	StrategyWeighted    = "weighted"
+)
+
+// Candidate is an active team member that may be assigned as a reviewer.
+type Candidate struct {
+	UserID string
+	// OpenReviews is the number of OPEN pull requests the user reviews.
+	OpenReviews int
+	// Weight is the relative chance of being picked by the weighted strategy.
+	Weight int
+	// MaxOpenReviews caps OpenReviews; 0 means the Registry default.
+	MaxOpenReviews int
+}
+
+// Selector picks at most n reviewers out of candidates for a pull request
+// owned by team. It never returns the same candidate twice.
+type Selector interface {
+	Select(team string, candidates []Candidate, n int) []string
+}
+
+// Registry holds one Selector per strategy, the strategy used by teams that
+// did not choose one and the open review cap for users without their own.
+type Registry struct {
+	selectors             map[string]Selector
+	defaultStrategy       string
+	defaultMaxOpenReviews int
+}
+
+// NewRegistry creates a Registry. defaultMaxOpenReviews of 0 leaves users
+// without their own cap unlimited.
+func NewRegistry(defaultStrategy string, defaultMaxOpenReviews int) (*Registry, error) {
+	r := &Registry{
+		selectors: map[string]Selector{
+			StrategyRandom:      Random{},
+			StrategyRoundRobin:  NewRoundRobin(),
+			StrategyLeastLoaded: LeastLoaded{},
+			StrategyWeighted:    Weighted{},
+		},
+		defaultStrategy:       defaultStrategy,
+		defaultMaxOpenReviews: defaultMaxOpenReviews,
+	}
+
+	if _, ok := r.selectors[defaultStrategy]; !ok {
+		return nil, fmt.Errorf("unknown reviewer strategy %q", defaultStrategy)
+	}
+	if defaultMaxOpenReviews < 0 {
+		return nil, fmt.Errorf("negative max open reviews %d", defaultMaxOpenReviews)
+	}
+
+	return r, nil
+}
+
+// NewDefaultRegistry returns a Registry whose default strategy is random,
+// without an open review cap.
+func NewDefaultRegistry() *Registry {
+	r, _ := NewRegistry(StrategyRandom, 0)
+	return r
+}
+
+// IsKnown reports whether strategy names a built-in strategy. The empty
+// string is accepted and means "use the default".
+func IsKnown(strategy string) bool {
+	switch strategy {
+	case "", StrategyRandom, StrategyRoundRobin, StrategyLeastLoaded, StrategyWeighted:
+		return true
+	}
+	return false
+}
+
+// For returns the Selector for strategy, falling back to the default one
+// when strategy is empty or unknown.
+func (r *Registry) For(strategy string) Selector {
+	if s, ok := r.selectors[strategy]; ok {
+		return s
+	}
+	return r.selectors[r.defaultStrategy]
+}
+
+// Select drops the candidates that reached their open review cap and lets
+// the Selector for strategy pick up to n of the rest.
+func (r *Registry) Select(strategy, team string, candidates []Candidate, n int) []string {
+	eligible := make([]Candidate, 0, len(candidates))
+	for _, c := range candidates {
+		limit := c.MaxOpenReviews
+		if limit == 0 {
+			limit = r.defaultMaxOpenReviews
+		}
+		if limit > 0 && c.OpenReviews >= limit {
+			continue
+		}
+		eligible = append(eligible, c)
+	}
+
+	return r.For(strategy).Select(team, eligible, n)
+}
+
+// Random picks uniformly at random.
+type Random struct{}
+
+func (Random) Select(_ string, candidates []Candidate, n int) []string {
+	shuffled := shuffle(candidates)
+	return ids(shuffled[:limit(n, len(shuffled))])
+}
+
+// RoundRobin walks every team's members in user ID order, continuing after
+// the member it picked last. The position is kept in memory, so it is per
+// process and starts over on restart.
+type RoundRobin struct {
+	mu   sync.Mutex
+	last map[string]string
+}
+
+func NewRoundRobin() *RoundRobin {
+	return &RoundRobin{last: make(map[string]string)}
+}
+
+func (rr *RoundRobin) Select(team string, candidates []Candidate, n int) []string {
+	if len(candidates) == 0 || n <= 0 {
+		return []string{}
+	}
+
+	sorted := make([]Candidate, len(candidates))
+	copy(sorted, candidates)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].UserID < sorted[j].UserID })
+
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	start := sort.Search(len(sorted), func(i int) bool { return sorted[i].UserID > rr.last[team] })
+
+	count := limit(n, len(sorted))
+	picked := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		picked = append(picked, sorted[(start+i)%len(sorted)].UserID)
+	}
+	rr.last[team] = picked[len(picked)-1]
+
+	return picked
+}
+
+// LeastLoaded prefers candidates with the fewest open reviews, breaking
+// ties randomly.
+type LeastLoaded struct{}
+
+func (LeastLoaded) Select(_ string, candidates []Candidate, n int) []string {
+	shuffled := shuffle(candidates)
+	sort.SliceStable(shuffled, func(i, j int) bool { return shuffled[i].OpenReviews < shuffled[j].OpenReviews })
+	return ids(shuffled[:limit(n, len(shuffled))])
+}
+
+// Weighted picks randomly without replacement, with the chance of being
+// picked proportional to Candidate.Weight. Candidates with a non-positive
+// weight are never picked.
+type Weighted struct{}
+
+func (Weighted) Select(_ string, candidates []Candidate, n int) []string {
+	type keyed struct {
+		id  string
+		key float64
+	}
+
+	// Efraimidis–Spirakis: take the n largest u^(1/w).
+	keys := make([]keyed, 0, len(candidates))
+	for _, c := range candidates {
+		if c.Weight <= 0 {
+			continue
+		}
+		keys = append(keys, keyed{id: c.UserID, key: math.Pow(rand.Float64(), 1/float64(c.Weight))})
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].key > keys[j].key })
+
+	picked := make([]string, 0, limit(n, len(keys)))
+	for _, k := range keys[:limit(n, len(keys))] {
+		picked = append(picked, k.id)
+	}
+	return picked
+}
+
+// limit clamps n to [0, size].
+func limit(n, size int) int {
+	return max(0, min(n, size))
+}
+
+func shuffle(candidates []Candidate) []Candidate {
+	shuffled := make([]Candidate, len(candidates))
+	copy(shuffled, candidates)
+	rand.Shuffle(len(shuffled), func(i, j int) {
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	})
+	return shuffled
+}
+
+func ids(candidates []Candidate) []string {
+	out := make([]string, 0, len(candidates))
+	for _, c := range candidates {
+		out = append(out, c.UserID)
+	}
+	return out
+}
@@ -2,39 +2,75 @@ package router
 
 import (
 	"log/slog"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	mwAuth "github.com/ten00m/golang-test-task/internal/http-server/middleware/auth"
+	mwDeadline "github.com/ten00m/golang-test-task/internal/http-server/middleware/deadline"
 	mwLogger "github.com/ten00m/golang-test-task/internal/http-server/middleware/logger"
+	mwMetrics "github.com/ten00m/golang-test-task/internal/http-server/middleware/metrics"
+	mwTracing "github.com/ten00m/golang-test-task/internal/http-server/middleware/tracing"
+	"github.com/ten00m/golang-test-task/internal/metrics"
 	"github.com/ten00m/golang-test-task/internal/storage"
 
 	handlers "github.com/ten00m/golang-test-task/internal/http-server/handlers"
 )
 
-func New(log *slog.Logger, storage *storage.DB) chi.Router {
+// New builds the router. bulkWriteTimeout is the write deadline of the
+// routes that hand over reviews and may outlast the server's write timeout.
+func New(log *slog.Logger, storage storage.Storage, adminToken string, m *metrics.Metrics, probe *handlers.Probe, bulkWriteTimeout time.Duration) chi.Router {
 	r := chi.NewRouter()
 
 	r.Use(middleware.RequestID)
+	r.Use(mwTracing.New())
 	r.Use(mwLogger.New(log))
+	r.Use(mwMetrics.New(m))
 	r.Use(middleware.RealIP)
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
+	r.Use(mwAuth.New(log, adminToken))
+
+	bulk := r.With(mwDeadline.New(log, bulkWriteTimeout))
 
 	// Teams
 	r.Post("/team/add", handlers.NewAddTeam(log, storage))
 	r.Get("/team/get", handlers.NewGetTeam(log, storage))
+	r.Get("/team/settings", handlers.NewGetTeamSettings(log, storage))
+	r.Post("/team/settings", handlers.NewUpdateTeamSettings(log, storage))
+	bulk.Post("/team/deactivateUsers", handlers.NewDeactivateTeamUsers(log, storage))
+	r.Post("/team/addMembers", handlers.NewAddTeamMembers(log, storage))
+	r.Post("/team/removeMembers", handlers.NewRemoveTeamMembers(log, storage))
+	r.Post("/team/rename", handlers.NewRenameTeam(log, storage))
+	r.Post("/team/delete", handlers.NewDeleteTeam(log, storage))
 
 	// Users
-	r.Post("/users/setIsActive", handlers.NewUsersSetIsActive(log, storage))
+	bulk.Post("/users/setIsActive", handlers.NewUsersSetIsActive(log, storage))
 	r.Get("/users/getReview", handlers.NewUsersGetReview(log, storage))
+	bulk.Post("/users/moveTeam", handlers.NewUsersMoveTeam(log, storage))
+	r.Post("/users/addAbsence", handlers.NewUsersAddAbsence(log, storage))
+	r.Get("/users/getAbsences", handlers.NewUsersGetAbsences(log, storage))
+	r.Post("/users/deleteAbsence", handlers.NewUsersDeleteAbsence(log, storage))
 
 	// Pull Requests
 	r.Post("/pullRequest/create", handlers.NewPullRequestCreate(log, storage))
 	r.Post("/pullRequest/merge", handlers.NewPullRequestMerge(log, storage))
 	r.Post("/pullRequest/reassign", handlers.NewPullRequestReassign(log, storage))
+	r.Post("/pullRequest/review", handlers.NewPullRequestReview(log, storage))
+	r.Post("/pullRequest/ready", handlers.NewPullRequestReady(log, storage))
+	r.Post("/pullRequest/close", handlers.NewPullRequestClose(log, storage))
+	r.Post("/pullRequest/reopen", handlers.NewPullRequestReopen(log, storage))
+
+	// Audit
+	r.Get("/audit", handlers.NewGetAudit(log, storage))
+
+	// Metrics
+	r.Handle("/metrics", m.Handler())
 
 	// Health
 	r.Get("/healthz", handlers.HealthCheck)
+	r.Get("/livez", handlers.Livez)
+	r.Get("/readyz", handlers.NewReadyz(log, storage, probe))
 
 	return r
 }
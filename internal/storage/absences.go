@@ -0,0 +1,214 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/ten00m/golang-test-task/internal/domain"
+	"github.com/ten00m/golang-test-task/internal/http-server/handlers"
+)
+
+// checkAbsence validates the window of absence and brings it to the UTC
+// microsecond precision the database keeps.
+func checkAbsence(absence handlers.Absence) (handlers.Absence, error) {
+	absence.Start = absence.Start.UTC().Truncate(time.Microsecond)
+	absence.End = absence.End.UTC().Truncate(time.Microsecond)
+	if !absence.End.After(absence.Start) {
+		return handlers.Absence{}, domain.ErrInvalidAbsence
+	}
+	return absence, nil
+}
+
+// AddAbsence schedules an absence of a user. Users are not picked as
+// reviewers while it lasts.
+func (db *DB) AddAbsence(ctx context.Context, actor string, absence handlers.Absence) (*handlers.Absence, error) {
+	const op = "Storage.AddAbsence"
+
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	absence, err := checkAbsence(absence)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	err = db.withTx(ctx, func(tx *sql.Tx) error {
+		var teamName sql.NullString
+		err := tx.QueryRowContext(ctx, `SELECT team_name FROM users WHERE id = $1`, absence.UserID).Scan(&teamName)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return domain.ErrUserNotFound
+			}
+			return err
+		}
+
+		err = tx.QueryRowContext(ctx, `
+			INSERT INTO user_absences (user_id, starts_at, ends_at, reason)
+			VALUES ($1, $2, $3, $4)
+			RETURNING id`,
+			absence.UserID, absence.Start, absence.End, nullString(absence.Reason)).Scan(&absence.ID)
+		if err != nil {
+			return err
+		}
+
+		return recordAudit(ctx, tx, handlers.AuditEvent{
+			Actor:    actor,
+			Action:   handlers.AuditUserAbsenceAdded,
+			UserID:   absence.UserID,
+			TeamName: teamName.String,
+			Details:  absenceDetails(absence),
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return &absence, nil
+}
+
+func absenceDetails(absence handlers.Absence) string {
+	return fmt.Sprintf("#%d %s - %s", absence.ID, absence.Start.Format(time.RFC3339), absence.End.Format(time.RFC3339))
+}
+
+// GetAbsences lists the absences of a user that have not ended yet, in
+// order of their start.
+func (db *DB) GetAbsences(ctx context.Context, userID string) ([]handlers.Absence, error) {
+	const op = "Storage.GetAbsences"
+
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	var exists bool
+	err := db.conn.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM users WHERE id = $1)`, userID).Scan(&exists)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if !exists {
+		return nil, fmt.Errorf("%s: %w", op, domain.ErrUserNotFound)
+	}
+
+	rows, err := db.conn.QueryContext(ctx, `
+		SELECT id, user_id, starts_at, ends_at, COALESCE(reason, '')
+		FROM user_absences
+		WHERE user_id = $1 AND ends_at > $2
+		ORDER BY starts_at, id`, userID, now())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	absences := make([]handlers.Absence, 0)
+	for rows.Next() {
+		var a handlers.Absence
+		if err := rows.Scan(&a.ID, &a.UserID, &a.Start, &a.End, &a.Reason); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		a.Start, a.End = a.Start.UTC(), a.End.UTC()
+		absences = append(absences, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return absences, nil
+}
+
+func (db *DB) DeleteAbsence(ctx context.Context, actor string, absenceID int64) error {
+	const op = "Storage.DeleteAbsence"
+
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	err := db.withTx(ctx, func(tx *sql.Tx) error {
+		var absence handlers.Absence
+		var teamName sql.NullString
+		err := tx.QueryRowContext(ctx, `
+			SELECT a.id, a.user_id, a.starts_at, a.ends_at, u.team_name
+			FROM user_absences a JOIN users u ON u.id = a.user_id
+			WHERE a.id = $1`, absenceID).Scan(&absence.ID, &absence.UserID, &absence.Start, &absence.End, &teamName)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return domain.ErrAbsenceNotFound
+			}
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM user_absences WHERE id = $1`, absenceID); err != nil {
+			return err
+		}
+
+		return recordAudit(ctx, tx, handlers.AuditEvent{
+			Actor:    actor,
+			Action:   handlers.AuditUserAbsenceDeleted,
+			UserID:   absence.UserID,
+			TeamName: teamName.String,
+			Details:  absenceDetails(absence),
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// ReassignAbsentReviewers hands over the open reviews of users whose absence
+// has started and has not been handed over yet. Every absence is handled
+// once; reviews assigned to the user afterwards, e.g. by hand, stay.
+func (db *DB) ReassignAbsentReviewers(ctx context.Context, actor string) (*handlers.ReassignReport, error) {
+	const op = "Storage.ReassignAbsentReviewers"
+
+	ctx, cancel := db.withBulkTimeout(ctx)
+	defer cancel()
+
+	var report *handlers.ReassignReport
+	err := db.withTx(ctx, func(tx *sql.Tx) error {
+		t := now()
+		rows, err := tx.QueryContext(ctx, `
+			SELECT id, user_id FROM user_absences
+			WHERE reassigned_at IS NULL AND starts_at <= $1 AND ends_at > $1
+			ORDER BY user_id, id`, t)
+		if err != nil {
+			return err
+		}
+		var absenceIDs []int64
+		var userIDs []string
+		for rows.Next() {
+			var absenceID int64
+			var userID string
+			if err := rows.Scan(&absenceID, &userID); err != nil {
+				rows.Close()
+				return err
+			}
+			absenceIDs = append(absenceIDs, absenceID)
+			if len(userIDs) == 0 || userIDs[len(userIDs)-1] != userID {
+				userIDs = append(userIDs, userID)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		report, err = db.reassignReviews(ctx, tx, actor, userIDs, "")
+		if err != nil {
+			return err
+		}
+
+		for chunk := range slices.Chunk(absenceIDs, maxBatch) {
+			args, in := placeholders([]any{t}, chunk)
+			if _, err := tx.ExecContext(ctx, `UPDATE user_absences SET reassigned_at = $1 WHERE id IN (`+in+`)`, args...); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return report, nil
+}
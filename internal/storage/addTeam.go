@@ -1,45 +1,86 @@
 package storage
 
 import (
+	"context"
+	"database/sql"
 	"fmt"
 
 	"github.com/google/uuid"
+	"github.com/ten00m/golang-test-task/internal/domain"
 	"github.com/ten00m/golang-test-task/internal/http-server/handlers"
 )
 
-func (s *DB) AddTeam(team handlers.Team) error {
+func (s *DB) AddTeam(ctx context.Context, actor string, team handlers.Team) error {
 	const op = "Storage.AddTeam"
 
-	stmt, err := s.conn.Prepare(`INSERT INTO teams (name) VALUES ($1)`)
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	settings, err := normalizeSettings(team.Name, team.Settings)
 	if err != nil {
-		return fmt.Errorf("%s: failed to prepare statement: %w", op, err)
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	for _, user := range team.Members {
+		if user.MaxOpenReviews < 0 {
+			return fmt.Errorf("%s: %w: max_open_reviews of %s", op, domain.ErrInvalidSettings, user.ID)
+		}
 	}
 
-	defer stmt.Close()
+	return s.withTx(ctx, func(tx *sql.Tx) error {
+		stmt, err := tx.PrepareContext(ctx, `
+			INSERT INTO teams (name, reviewer_strategy, reviewers_per_pr, min_reviewers,
+				required_approvals, block_on_changes_requested, require_all_reviewers)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)`)
+		if err != nil {
+			return fmt.Errorf("%s: failed to prepare statement: %w", op, err)
+		}
 
-	_, err = stmt.Exec(team.Name)
-	if err != nil {
-		return fmt.Errorf("%s: failed to execute statement: %w", op, err)
-	}
+		defer stmt.Close()
 
-	err = addFKsForTeamsAndUsers(s, team.Members, team.Name)
-	if err != nil {
-		return fmt.Errorf("%s: failed to add FKs for teams and users: %w", op, err)
-	}
+		_, err = stmt.ExecContext(ctx, team.Name, nullString(settings.ReviewerStrategy), settings.ReviewersPerPR, settings.MinReviewers,
+			settings.MergePolicy.RequiredApprovals, settings.MergePolicy.BlockOnChangesRequested,
+			settings.MergePolicy.RequireAllReviewers)
+		if isUniqueViolation(err) {
+			return fmt.Errorf("%s: %w", op, domain.ErrTeamExists)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: failed to execute statement: %w", op, err)
+		}
 
-	return nil
+		err = setFallbackTeams(ctx, tx, team.Name, settings.FallbackTeams)
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+
+		err = addFKsForTeamsAndUsers(ctx, tx, team.Members, team.Name)
+		if err != nil {
+			return fmt.Errorf("%s: failed to add FKs for teams and users: %w", op, err)
+		}
+
+		return recordAudit(ctx, tx, handlers.AuditEvent{
+			Actor:    actor,
+			Action:   handlers.AuditTeamCreated,
+			TeamName: team.Name,
+			Details:  fmt.Sprintf("%d members", len(team.Members)),
+		})
+	})
 }
 
-func addFKsForTeamsAndUsers(db *DB, users []handlers.User, teamName string) error {
+func addFKsForTeamsAndUsers(ctx context.Context, tx *sql.Tx, users []handlers.User, teamName string) error {
 	const op = "Storage.addFKsForTeamsAndUsers"
 
-	insertUserStmt, err := db.conn.Prepare(`INSERT INTO users (id, username, is_active, team_name) VALUES ($1, $2, $3, $4)
-		ON CONFLICT (id) DO UPDATE SET username = EXCLUDED.username, is_active = EXCLUDED.is_active, team_name = EXCLUDED.team_name`)
+	insertUserStmt, err := tx.PrepareContext(ctx, `INSERT INTO users (id, username, is_active, team_name, review_weight, max_open_reviews)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (id) DO UPDATE SET username = EXCLUDED.username, is_active = EXCLUDED.is_active,
+			team_name = EXCLUDED.team_name, review_weight = EXCLUDED.review_weight,
+			max_open_reviews = EXCLUDED.max_open_reviews`)
 	if err != nil {
 		return fmt.Errorf("%s: failed to prepare insertUser statement: %w", op, err)
 	}
 
-	insertFKsStmt, err := db.conn.Prepare(`INSERT INTO team_fk_user (team_name, user_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`)
+	insertFKsStmt, err := tx.PrepareContext(ctx, `
+		INSERT INTO team_fk_user (team_name, user_id)
+		SELECT $1, $2 WHERE NOT EXISTS (SELECT 1 FROM team_fk_user WHERE team_name = $1 AND user_id = $2)`)
 	if err != nil {
 		return fmt.Errorf("%s: failed to prepare insertFKs statement: %w", op, err)
 	}
@@ -58,12 +99,25 @@ func addFKsForTeamsAndUsers(db *DB, users []handlers.User, teamName string) erro
 			tName = teamName
 		}
 
-		_, err := insertUserStmt.Exec(id, user.Username, user.IsActive, tName)
+		weight := user.ReviewWeight
+		if weight <= 0 {
+			weight = 1
+		}
+
+		maxOpenReviews := sql.NullInt64{Int64: int64(user.MaxOpenReviews), Valid: user.MaxOpenReviews > 0}
+
+		_, err := insertUserStmt.ExecContext(ctx, id, user.Username, user.IsActive, tName, weight, maxOpenReviews)
+		if isUniqueViolation(err) {
+			return fmt.Errorf("%s: %w: %s", op, domain.ErrUsernameTaken, user.Username)
+		}
+		if isForeignKeyViolation(err) {
+			return fmt.Errorf("%s: %w: %s", op, domain.ErrTeamNotFound, tName)
+		}
 		if err != nil {
 			return fmt.Errorf("%s: failed to execute insertUser statement: %w", op, err)
 		}
 
-		_, err = insertFKsStmt.Exec(tName, id)
+		_, err = insertFKsStmt.ExecContext(ctx, tName, id)
 		if err != nil {
 			return fmt.Errorf("%s: failed to execute insertFKs statement: %w", op, err)
 		}
@@ -72,33 +126,48 @@ func addFKsForTeamsAndUsers(db *DB, users []handlers.User, teamName string) erro
 	return nil
 }
 
-func (s *DB) GetTeam(teamName string) (handlers.Team, error) {
+func (s *DB) GetTeam(ctx context.Context, teamName string) (handlers.Team, error) {
 	const op = "Storage.GetTeam"
 
-	var name string
-	err := s.conn.QueryRow(`SELECT name FROM teams WHERE name = $1`, teamName).Scan(&name)
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	team, err := getTeam(ctx, s.conn, teamName)
 	if err != nil {
 		return handlers.Team{}, fmt.Errorf("%s: %w", op, err)
 	}
 
-	rows, err := s.conn.Query(`SELECT id, username, is_active, team_name FROM users WHERE team_name = $1`, teamName)
+	return team, nil
+}
+
+func getTeam(ctx context.Context, q queryer, teamName string) (handlers.Team, error) {
+	settings, err := getTeamSettings(ctx, q, teamName)
 	if err != nil {
-		return handlers.Team{}, fmt.Errorf("%s: %w", op, err)
+		return handlers.Team{}, err
+	}
+
+	rows, err := q.QueryContext(ctx, `SELECT `+userColumns+` FROM users WHERE team_name = $1`, teamName)
+	if err != nil {
+		return handlers.Team{}, err
 	}
 	defer rows.Close()
 
 	members := make([]handlers.User, 0)
 	for rows.Next() {
-		var u handlers.User
-		if err := rows.Scan(&u.ID, &u.Username, &u.IsActive, &u.TeamName); err != nil {
-			return handlers.Team{}, fmt.Errorf("%s: %w", op, err)
+		u, err := scanUser(rows)
+		if err != nil {
+			return handlers.Team{}, err
 		}
 		members = append(members, u)
 	}
 
 	if err := rows.Err(); err != nil {
-		return handlers.Team{}, fmt.Errorf("%s: %w", op, err)
+		return handlers.Team{}, err
 	}
 
-	return handlers.Team{Name: name, Members: members}, nil
+	return handlers.Team{
+		Name:     teamName,
+		Members:  members,
+		Settings: *settings,
+	}, nil
 }
@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/ten00m/golang-test-task/internal/http-server/handlers"
+)
+
+// recordAudit appends e to the audit log. It runs in the transaction of the
+// mutation it records, so the log never disagrees with the data.
+func recordAudit(ctx context.Context, q queryer, e handlers.AuditEvent) error {
+	_, err := q.ExecContext(ctx, `
+		INSERT INTO audit_events (created_at, actor, action, pr_id, user_id, old_reviewer_id, new_reviewer_id, team_name, details)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		now(), nullString(e.Actor), e.Action, nullString(e.PRID), nullString(e.UserID),
+		nullString(e.OldReviewerID), nullString(e.NewReviewerID), nullString(e.TeamName), nullString(e.Details))
+	if err != nil {
+		return fmt.Errorf("failed to record audit event %s: %w", e.Action, err)
+	}
+	return nil
+}
+
+// recordAudits appends events to the audit log with one INSERT per
+// maxBatch bound values.
+func recordAudits(ctx context.Context, q queryer, events []handlers.AuditEvent) error {
+	createdAt := now()
+	for chunk := range slices.Chunk(events, maxBatch/9) {
+		args := make([]any, 0, len(chunk)*9)
+		values := make([]string, 0, len(chunk))
+		for _, e := range chunk {
+			n := len(args)
+			values = append(values, fmt.Sprintf("($%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d)",
+				n+1, n+2, n+3, n+4, n+5, n+6, n+7, n+8, n+9))
+			args = append(args, createdAt, nullString(e.Actor), e.Action, nullString(e.PRID), nullString(e.UserID),
+				nullString(e.OldReviewerID), nullString(e.NewReviewerID), nullString(e.TeamName), nullString(e.Details))
+		}
+
+		_, err := q.ExecContext(ctx, `
+			INSERT INTO audit_events (created_at, actor, action, pr_id, user_id, old_reviewer_id, new_reviewer_id, team_name, details)
+			VALUES `+strings.Join(values, ", "), args...)
+		if err != nil {
+			return fmt.Errorf("failed to record %d audit events: %w", len(chunk), err)
+		}
+	}
+	return nil
+}
+
+func (db *DB) GetAuditEvents(ctx context.Context, filter handlers.AuditFilter) (*handlers.AuditPage, error) {
+	const op = "Storage.GetAuditEvents"
+
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		SELECT id, created_at, actor, action, pr_id, user_id, old_reviewer_id, new_reviewer_id, team_name, details
+		FROM audit_events
+		WHERE id > $1
+	`
+
+	args := []any{filter.After}
+	if filter.PRID != "" {
+		args = append(args, filter.PRID)
+		query += fmt.Sprintf(" AND pr_id = $%d", len(args))
+	}
+	if filter.UserID != "" {
+		args = append(args, filter.UserID)
+		query += fmt.Sprintf(" AND (user_id = $%[1]d OR old_reviewer_id = $%[1]d OR new_reviewer_id = $%[1]d)", len(args))
+	}
+	if !filter.Since.IsZero() {
+		args = append(args, filter.Since.UTC())
+		query += fmt.Sprintf(" AND created_at >= $%d", len(args))
+	}
+
+	// One extra row tells whether there is a next page.
+	args = append(args, filter.Limit+1)
+	query += fmt.Sprintf(" ORDER BY id LIMIT $%d", len(args))
+
+	rows, err := db.conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	events := make([]handlers.AuditEvent, 0)
+	for rows.Next() {
+		var e handlers.AuditEvent
+		var actor, prID, userID, oldReviewerID, newReviewerID, teamName, details sql.NullString
+		err := rows.Scan(&e.ID, &e.CreatedAt, &actor, &e.Action, &prID, &userID,
+			&oldReviewerID, &newReviewerID, &teamName, &details)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		e.CreatedAt = e.CreatedAt.UTC()
+		e.Actor, e.PRID, e.UserID = actor.String, prID.String, userID.String
+		e.OldReviewerID, e.NewReviewerID = oldReviewerID.String, newReviewerID.String
+		e.TeamName, e.Details = teamName.String, details.String
+		events = append(events, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return auditPage(events, filter.Limit), nil
+}
+
+// auditPage cuts events, fetched with one extra row, down to limit.
+func auditPage(events []handlers.AuditEvent, limit int) *handlers.AuditPage {
+	page := &handlers.AuditPage{Events: events}
+	if len(events) > limit {
+		page.Events = events[:limit]
+		next := page.Events[limit-1].ID
+		page.NextCursor = &next
+	}
+	return page
+}
@@ -0,0 +1,278 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/ten00m/golang-test-task/internal/domain"
+	"github.com/ten00m/golang-test-task/internal/http-server/handlers"
+)
+
+// seedTeams adds backend (u1-u3) and frontend (u4, u5) and has u1 open pr-1,
+// which gets u2 and u3 as reviewers.
+func seedTeams(t *testing.T, s Storage) *handlers.PullRequest {
+	t.Helper()
+
+	ctx := context.Background()
+	teams := []handlers.Team{
+		{Name: "backend", Members: []handlers.User{
+			{ID: "u1", Username: "alice", IsActive: true},
+			{ID: "u2", Username: "bob", IsActive: true},
+			{ID: "u3", Username: "carol", IsActive: true},
+		}},
+		{Name: "frontend", Members: []handlers.User{
+			{ID: "u4", Username: "dave", IsActive: true},
+			{ID: "u5", Username: "erin", IsActive: true},
+		}},
+	}
+	for _, team := range teams {
+		if err := s.AddTeam(ctx, "test", team); err != nil {
+			t.Fatalf("add team %s: %v", team.Name, err)
+		}
+	}
+
+	pr, err := s.CreatePullRequest(ctx, "test", "pr-1", "first", "u1", false)
+	if err != nil {
+		t.Fatalf("create PR: %v", err)
+	}
+	return pr
+}
+
+func useFallbackTeams(t *testing.T, s Storage, teamName string, fallback ...string) {
+	t.Helper()
+
+	_, err := s.UpdateTeamSettings(context.Background(), "test", teamName, handlers.TeamSettings{FallbackTeams: fallback})
+	if err != nil {
+		t.Fatalf("update settings of %s: %v", teamName, err)
+	}
+}
+
+func sorted(ids []string) []string {
+	return slices.Sorted(slices.Values(ids))
+}
+
+// TestBackendsAgree runs the same scenarios against every backend, so that
+// Memory keeps the semantics of the DB backend.
+func TestBackendsAgree(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		run  func(t *testing.T, s Storage)
+	}{
+		{"duplicate team", func(t *testing.T, s Storage) {
+			seedTeams(t, s)
+			err := s.AddTeam(ctx, "test", handlers.Team{Name: "backend"})
+			if !errors.Is(err, domain.ErrTeamExists) {
+				t.Errorf("expected TEAM_EXISTS, got %v", err)
+			}
+		}},
+		{"unknown author", func(t *testing.T, s Storage) {
+			seedTeams(t, s)
+			_, err := s.CreatePullRequest(ctx, "test", "pr-2", "second", "nobody", false)
+			if !errors.Is(err, domain.ErrAuthorNotFound) {
+				t.Errorf("expected author not found, got %v", err)
+			}
+		}},
+		{"duplicate PR", func(t *testing.T, s Storage) {
+			seedTeams(t, s)
+			_, err := s.CreatePullRequest(ctx, "test", "pr-1", "again", "u4", false)
+			if !errors.Is(err, domain.ErrPRExists) {
+				t.Errorf("expected PR_EXISTS, got %v", err)
+			}
+		}},
+		{"unknown PR", func(t *testing.T, s Storage) {
+			seedTeams(t, s)
+			if _, err := s.GetPullRequest(ctx, "nope"); !errors.Is(err, domain.ErrPRNotFound) {
+				t.Errorf("get: expected PR not found, got %v", err)
+			}
+			if _, _, err := s.MergePullRequest(ctx, "test", "nope", false); !errors.Is(err, domain.ErrPRNotFound) {
+				t.Errorf("merge: expected PR not found, got %v", err)
+			}
+		}},
+		{"reassign a reviewer that is not assigned", func(t *testing.T, s Storage) {
+			seedTeams(t, s)
+			_, err := s.ReassignReviewer(ctx, "test", "pr-1", "u4")
+			if !errors.Is(err, domain.ErrNotAssigned) {
+				t.Errorf("expected NOT_ASSIGNED, got %v", err)
+			}
+		}},
+		{"invalid review state", func(t *testing.T, s Storage) {
+			seedTeams(t, s)
+			_, err := s.SubmitReview(ctx, "test", "pr-1", "u2", "LGTM")
+			if !errors.Is(err, domain.ErrInvalidReviewState) {
+				t.Errorf("expected invalid review state, got %v", err)
+			}
+		}},
+		{"changes after merge", func(t *testing.T, s Storage) {
+			seedTeams(t, s)
+			if _, _, err := s.MergePullRequest(ctx, "test", "pr-1", false); err != nil {
+				t.Fatalf("merge: %v", err)
+			}
+			if _, err := s.ReassignReviewer(ctx, "test", "pr-1", "u2"); !errors.Is(err, domain.ErrPRMerged) {
+				t.Errorf("reassign: expected PR_MERGED, got %v", err)
+			}
+			if _, err := s.SubmitReview(ctx, "test", "pr-1", "u2", handlers.ReviewApproved); !errors.Is(err, domain.ErrReviewMerged) {
+				t.Errorf("review: expected PR_MERGED, got %v", err)
+			}
+			if _, err := s.ClosePullRequest(ctx, "test", "pr-1"); !errors.Is(err, domain.ErrPRMerged) && !errors.Is(err, domain.ErrPRIsMerged) {
+				t.Errorf("close: expected PR_MERGED, got %v", err)
+			}
+		}},
+		{"merge is idempotent", func(t *testing.T, s Storage) {
+			seedTeams(t, s)
+			first, merged, err := s.MergePullRequest(ctx, "test", "pr-1", false)
+			if err != nil || !merged {
+				t.Fatalf("first merge: merged=%v, err=%v", merged, err)
+			}
+			second, merged, err := s.MergePullRequest(ctx, "test", "pr-1", false)
+			if err != nil || merged {
+				t.Fatalf("second merge: merged=%v, err=%v", merged, err)
+			}
+			if second.Status != handlers.PRStatusMerged || second.MergedAt == nil || !second.MergedAt.Equal(*first.MergedAt) {
+				t.Errorf("expected the first merge to stand, got %s at %v, merged first at %v", second.Status, second.MergedAt, first.MergedAt)
+			}
+		}},
+		{"merge policy", func(t *testing.T, s Storage) {
+			seedTeams(t, s)
+			_, err := s.UpdateTeamSettings(ctx, "test", "backend", handlers.TeamSettings{
+				MergePolicy: handlers.MergePolicy{RequiredApprovals: 1},
+			})
+			if err != nil {
+				t.Fatalf("update settings: %v", err)
+			}
+			if _, _, err := s.MergePullRequest(ctx, "test", "pr-1", false); !errors.Is(err, domain.ErrMergeBlocked) {
+				t.Errorf("expected MERGE_BLOCKED, got %v", err)
+			}
+			if _, err := s.SubmitReview(ctx, "test", "pr-1", "u2", handlers.ReviewApproved); err != nil {
+				t.Fatalf("approve: %v", err)
+			}
+			if _, merged, err := s.MergePullRequest(ctx, "test", "pr-1", false); err != nil || !merged {
+				t.Errorf("expected the approved PR to merge, got merged=%v, err=%v", merged, err)
+			}
+		}},
+		{"merge of a closed PR", func(t *testing.T, s Storage) {
+			seedTeams(t, s)
+			if _, err := s.ClosePullRequest(ctx, "test", "pr-1"); err != nil {
+				t.Fatalf("close: %v", err)
+			}
+			if _, _, err := s.MergePullRequest(ctx, "test", "pr-1", false); !errors.Is(err, domain.ErrPRClosed) {
+				t.Errorf("expected PR_CLOSED, got %v", err)
+			}
+		}},
+		{"merge when the author left the team", func(t *testing.T, s Storage) {
+			seedTeams(t, s)
+			if _, err := s.ClosePullRequest(ctx, "test", "pr-1"); err != nil {
+				t.Fatalf("close: %v", err)
+			}
+			if _, err := s.RemoveTeamMembers(ctx, "test", "backend", []string{"u1"}); err != nil {
+				t.Fatalf("remove author: %v", err)
+			}
+			if _, err := s.ReopenPullRequest(ctx, "test", "pr-1"); err != nil {
+				t.Fatalf("reopen: %v", err)
+			}
+			if _, _, err := s.MergePullRequest(ctx, "test", "pr-1", false); !errors.Is(err, domain.ErrTeamNotFound) {
+				t.Errorf("expected team not found, got %v", err)
+			}
+			if _, merged, err := s.MergePullRequest(ctx, "test", "pr-1", true); err != nil || !merged {
+				t.Errorf("expected a forced merge to go through, got merged=%v, err=%v", merged, err)
+			}
+		}},
+		{"reviewers from a fallback team", func(t *testing.T, s Storage) {
+			seedTeams(t, s)
+			useFallbackTeams(t, s, "backend", "frontend")
+			if _, _, err := s.SetUserIsActive(ctx, "test", "u3", false); err != nil {
+				t.Fatalf("deactivate: %v", err)
+			}
+
+			pr, err := s.CreatePullRequest(ctx, "test", "pr-2", "second", "u1", false)
+			if err != nil {
+				t.Fatalf("create PR: %v", err)
+			}
+			if len(pr.AssignedReviewers) != 2 || !slices.Contains(pr.AssignedReviewers, "u2") {
+				t.Fatalf("expected u2 and one frontend reviewer, got %v", pr.AssignedReviewers)
+			}
+			fallback := slices.DeleteFunc(slices.Clone(pr.AssignedReviewers), func(id string) bool { return id == "u2" })
+			if !slices.Equal(pr.FallbackReviewers, fallback) {
+				t.Errorf("expected fallback reviewers %v, got %v", fallback, pr.FallbackReviewers)
+			}
+		}},
+		{"reassign without a candidate", func(t *testing.T, s Storage) {
+			seedTeams(t, s)
+			_, err := s.ReassignReviewer(ctx, "test", "pr-1", "u2")
+			if !errors.Is(err, domain.ErrNoCandidate) {
+				t.Errorf("expected NO_CANDIDATE, got %v", err)
+			}
+		}},
+		{"reassign to a fallback team", func(t *testing.T, s Storage) {
+			seedTeams(t, s)
+			useFallbackTeams(t, s, "backend", "frontend")
+
+			newReviewerID, err := s.ReassignReviewer(ctx, "test", "pr-1", "u2")
+			if err != nil {
+				t.Fatalf("reassign: %v", err)
+			}
+			if newReviewerID != "u4" && newReviewerID != "u5" {
+				t.Fatalf("expected a frontend reviewer, got %s", newReviewerID)
+			}
+
+			pr, err := s.GetPullRequest(ctx, "pr-1")
+			if err != nil {
+				t.Fatalf("get PR: %v", err)
+			}
+			if want := sorted([]string{"u3", newReviewerID}); !slices.Equal(sorted(pr.AssignedReviewers), want) {
+				t.Errorf("expected reviewers %v, got %v", want, pr.AssignedReviewers)
+			}
+			if !slices.Equal(pr.FallbackReviewers, []string{newReviewerID}) {
+				t.Errorf("expected %s to be a fallback reviewer, got %v", newReviewerID, pr.FallbackReviewers)
+			}
+		}},
+		{"deactivation without a candidate", func(t *testing.T, s Storage) {
+			seedTeams(t, s)
+			_, report, err := s.SetUserIsActive(ctx, "test", "u2", false)
+			if err != nil {
+				t.Fatalf("deactivate: %v", err)
+			}
+			if len(report.Reassigned) != 0 || len(report.Failed) != 1 {
+				t.Fatalf("expected one failed reassignment, got %+v", report)
+			}
+			if failed := report.Failed[0]; failed.PRID != "pr-1" || failed.OldReviewerID != "u2" || failed.Reason != domain.ErrNoCandidate.Error() {
+				t.Errorf("unexpected failed reassignment %+v", failed)
+			}
+		}},
+		{"deactivation hands reviews to a fallback team", func(t *testing.T, s Storage) {
+			seedTeams(t, s)
+			useFallbackTeams(t, s, "backend", "frontend")
+
+			res, err := s.DeactivateTeamUsers(ctx, "test", "backend", []string{"u2", "u3"}, false)
+			if err != nil {
+				t.Fatalf("deactivate: %v", err)
+			}
+			report := res.Reassignment
+			if len(report.Failed) != 0 || len(report.Reassigned) != 2 {
+				t.Fatalf("expected two reassignments, got %+v", report)
+			}
+
+			pr, err := s.GetPullRequest(ctx, "pr-1")
+			if err != nil {
+				t.Fatalf("get PR: %v", err)
+			}
+			if want := []string{"u4", "u5"}; !slices.Equal(sorted(pr.AssignedReviewers), want) ||
+				!slices.Equal(sorted(pr.FallbackReviewers), want) {
+				t.Errorf("expected fallback reviewers %v, got %v (fallback %v)", want, pr.AssignedReviewers, pr.FallbackReviewers)
+			}
+		}},
+	}
+
+	for name, newStorage := range testBackends() {
+		t.Run(name, func(t *testing.T) {
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					tt.run(t, newStorage(t))
+				})
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/ten00m/golang-test-task/internal/domain"
+	"github.com/ten00m/golang-test-task/internal/http-server/handlers"
+)
+
+// requireOpen returns the error for acting on the reviews of a PR that is
+// not OPEN; mergedErr is used for MERGED PRs.
+func requireOpen(status string, mergedErr error) error {
+	switch status {
+	case handlers.PRStatusMerged:
+		return mergedErr
+	case handlers.PRStatusDraft:
+		return domain.ErrPRDraft
+	case handlers.PRStatusClosed:
+		return domain.ErrPRClosed
+	}
+	return nil
+}
+
+// ReadyPullRequest turns a draft into an OPEN PR and assigns its reviewers.
+// Marking an OPEN PR ready is a no-op.
+func (db *DB) ReadyPullRequest(ctx context.Context, actor, prID string) (*handlers.PullRequest, error) {
+	const op = "Storage.ReadyPullRequest"
+
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	pr, err := db.transition(ctx, actor, prID, handlers.AuditPRReady, func(tx *sql.Tx, pr *handlers.PullRequest) (string, error) {
+		switch pr.Status {
+		case handlers.PRStatusOpen:
+			return "", nil
+		case handlers.PRStatusMerged:
+			return "", domain.ErrPRIsMerged
+		case handlers.PRStatusClosed:
+			return "", domain.ErrPRClosed
+		}
+		return handlers.PRStatusOpen, db.assignReviewers(ctx, tx, actor, pr.ID, pr.AuthorID)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return pr, nil
+}
+
+// ClosePullRequest abandons a DRAFT or OPEN PR without merging it. Closing a
+// CLOSED PR is a no-op.
+func (db *DB) ClosePullRequest(ctx context.Context, actor, prID string) (*handlers.PullRequest, error) {
+	const op = "Storage.ClosePullRequest"
+
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	pr, err := db.transition(ctx, actor, prID, handlers.AuditPRClosed, func(tx *sql.Tx, pr *handlers.PullRequest) (string, error) {
+		switch pr.Status {
+		case handlers.PRStatusClosed:
+			return "", nil
+		case handlers.PRStatusMerged:
+			return "", domain.ErrPRIsMerged
+		}
+		return handlers.PRStatusClosed, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return pr, nil
+}
+
+// ReopenPullRequest makes a CLOSED PR OPEN again. A PR that was closed as a
+// draft gets its reviewers now. Reopening a DRAFT or OPEN PR is a no-op.
+func (db *DB) ReopenPullRequest(ctx context.Context, actor, prID string) (*handlers.PullRequest, error) {
+	const op = "Storage.ReopenPullRequest"
+
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	pr, err := db.transition(ctx, actor, prID, handlers.AuditPRReopened, func(tx *sql.Tx, pr *handlers.PullRequest) (string, error) {
+		switch pr.Status {
+		case handlers.PRStatusDraft, handlers.PRStatusOpen:
+			return "", nil
+		case handlers.PRStatusMerged:
+			return "", domain.ErrPRIsMerged
+		}
+		if len(pr.AssignedReviewers) == 0 {
+			return handlers.PRStatusOpen, db.assignReviewers(ctx, tx, actor, pr.ID, pr.AuthorID)
+		}
+		return handlers.PRStatusOpen, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return pr, nil
+}
+
+// transition loads the PR and moves it to the status returned by fn, all in
+// one transaction, recording action in the audit log. An empty status leaves
+// the PR as it is.
+func (db *DB) transition(ctx context.Context, actor, prID, action string, fn func(tx *sql.Tx, pr *handlers.PullRequest) (string, error)) (*handlers.PullRequest, error) {
+	var pr *handlers.PullRequest
+	err := db.withTx(ctx, func(tx *sql.Tx) error {
+		current, err := getPullRequest(ctx, tx, prID)
+		if err != nil {
+			return err
+		}
+
+		status, err := fn(tx, current)
+		if err != nil {
+			return err
+		}
+		if status == "" {
+			pr = current
+			return nil
+		}
+
+		updatedAt := now()
+		closedAt := sql.NullTime{Time: updatedAt, Valid: status == handlers.PRStatusClosed}
+		_, err = tx.ExecContext(ctx, `UPDATE pull_requests SET status = $1, updated_at = $2, closed_at = $3 WHERE id = $4`,
+			status, updatedAt, closedAt, prID)
+		if err != nil {
+			return err
+		}
+
+		err = recordAudit(ctx, tx, handlers.AuditEvent{Actor: actor, Action: action, PRID: prID, Details: current.Status + " -> " + status})
+		if err != nil {
+			return err
+		}
+
+		pr, err = getPullRequest(ctx, tx, prID)
+		return err
+	})
+
+	return pr, err
+}
@@ -0,0 +1,1362 @@
+package storage
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ten00m/golang-test-task/internal/domain"
+	"github.com/ten00m/golang-test-task/internal/http-server/handlers"
+	"github.com/ten00m/golang-test-task/internal/reviewer"
+)
+
+// Memory is a thread-safe in-memory Storage. It mirrors the semantics of DB
+// and is meant for local development and CI, where no Postgres is available.
+type Memory struct {
+	mu        sync.RWMutex
+	log       *slog.Logger
+	selectors *reviewer.Registry
+	// reassignOnDeactivate mirrors DB.reassignOnDeactivate.
+	reassignOnDeactivate bool
+
+	teams map[string]handlers.TeamSettings
+
+	users     map[string]handlers.User
+	userOrder []string
+
+	pullRequests map[string]*memPullRequest
+	prOrder      []string
+
+	absences  []memAbsence
+	absenceID int64
+
+	audit []handlers.AuditEvent
+}
+
+type memAbsence struct {
+	handlers.Absence
+	// reassigned is set once the reviews of the user have been handed over.
+	reassigned bool
+}
+
+type memPullRequest struct {
+	id        string
+	title     string
+	authorID  string
+	status    string
+	reviewers []string
+	// fallback marks the reviewers picked from a fallback team.
+	fallback map[string]bool
+	// states holds the review state of every reviewer.
+	states map[string]string
+	// assignedAt holds when every reviewer was assigned.
+	assignedAt map[string]time.Time
+
+	createdAt time.Time
+	updatedAt time.Time
+	mergedAt  *time.Time
+	closedAt  *time.Time
+}
+
+func NewMemory(log *slog.Logger) *Memory {
+	log.Info("using in-memory storage")
+
+	return &Memory{
+		log:                  log,
+		selectors:            reviewer.NewDefaultRegistry(),
+		reassignOnDeactivate: true,
+		teams:                make(map[string]handlers.TeamSettings),
+		users:                make(map[string]handlers.User),
+		pullRequests:         make(map[string]*memPullRequest),
+	}
+}
+
+func (m *Memory) AddTeam(ctx context.Context, actor string, team handlers.Team) error {
+	const op = "Storage.AddTeam"
+
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	settings, err := normalizeSettings(team.Name, team.Settings)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if err := m.checkFallbackTeams(settings.FallbackTeams); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if _, ok := m.teams[team.Name]; ok {
+		return fmt.Errorf("%s: %w", op, domain.ErrTeamExists)
+	}
+
+	users, err := m.prepareMembers(team.Name, team.Members)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	settings.FallbackTeams = slices.Clone(settings.FallbackTeams)
+	m.teams[team.Name] = settings
+	m.putUsers(users)
+
+	m.record(handlers.AuditEvent{
+		Actor:    actor,
+		Action:   handlers.AuditTeamCreated,
+		TeamName: team.Name,
+		Details:  fmt.Sprintf("%d members", len(team.Members)),
+	})
+
+	return nil
+}
+
+// prepareMembers validates members joining teamName the way the users table
+// would and fills in their defaults. The caller must hold m.mu.
+func (m *Memory) prepareMembers(teamName string, members []handlers.User) ([]handlers.User, error) {
+	users := make([]handlers.User, 0, len(members))
+	usernames := make(map[string]string, len(members))
+	for _, user := range members {
+		id := user.ID
+		if id == "" {
+			id = uuid.New().String()
+		}
+
+		tName := user.TeamName
+		if tName == "" {
+			tName = teamName
+		}
+		if _, ok := m.teams[tName]; !ok && tName != teamName {
+			return nil, fmt.Errorf("%w: %s", domain.ErrTeamNotFound, tName)
+		}
+
+		if owner, ok := m.usernameOwner(user.Username); ok && owner != id {
+			return nil, fmt.Errorf("%w: %s", domain.ErrUsernameTaken, user.Username)
+		}
+		if owner, ok := usernames[user.Username]; ok && owner != id {
+			return nil, fmt.Errorf("%w: %s", domain.ErrUsernameTaken, user.Username)
+		}
+		usernames[user.Username] = id
+
+		weight := user.ReviewWeight
+		if weight <= 0 {
+			weight = 1
+		}
+
+		if user.MaxOpenReviews < 0 {
+			return nil, fmt.Errorf("%w: max_open_reviews of %s", domain.ErrInvalidSettings, id)
+		}
+
+		users = append(users, handlers.User{
+			ID:             id,
+			Username:       user.Username,
+			IsActive:       user.IsActive,
+			TeamName:       tName,
+			ReviewWeight:   weight,
+			MaxOpenReviews: user.MaxOpenReviews,
+		})
+	}
+
+	return users, nil
+}
+
+func (m *Memory) putUsers(users []handlers.User) {
+	for _, user := range users {
+		if _, ok := m.users[user.ID]; !ok {
+			m.userOrder = append(m.userOrder, user.ID)
+		}
+		m.users[user.ID] = user
+	}
+}
+
+func (m *Memory) AddTeamMembers(ctx context.Context, actor, teamName string, members []handlers.User) (handlers.Team, error) {
+	const op = "Storage.AddTeamMembers"
+
+	if err := ctx.Err(); err != nil {
+		return handlers.Team{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.teams[teamName]; !ok {
+		return handlers.Team{}, fmt.Errorf("%s: %w", op, domain.ErrTeamNotFound)
+	}
+
+	members = slices.Clone(members)
+	for i, user := range members {
+		if current, ok := m.users[user.ID]; ok && current.TeamName != "" && current.TeamName != teamName {
+			return handlers.Team{}, fmt.Errorf("%s: %w: %s is in team %s", op, domain.ErrUserInOtherTeam, user.ID, current.TeamName)
+		}
+		members[i].TeamName = teamName
+	}
+
+	users, err := m.prepareMembers(teamName, members)
+	if err != nil {
+		return handlers.Team{}, fmt.Errorf("%s: %w", op, err)
+	}
+	m.putUsers(users)
+
+	for _, user := range users {
+		m.record(handlers.AuditEvent{Actor: actor, Action: handlers.AuditTeamMemberAdded, UserID: user.ID, TeamName: teamName})
+	}
+
+	return m.team(teamName), nil
+}
+
+func (m *Memory) RemoveTeamMembers(ctx context.Context, actor, teamName string, userIDs []string) (handlers.Team, error) {
+	const op = "Storage.RemoveTeamMembers"
+
+	if err := ctx.Err(); err != nil {
+		return handlers.Team{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.teams[teamName]; !ok {
+		return handlers.Team{}, fmt.Errorf("%s: %w", op, domain.ErrTeamNotFound)
+	}
+
+	for _, userID := range userIDs {
+		if user, ok := m.users[userID]; !ok || user.TeamName != teamName {
+			return handlers.Team{}, fmt.Errorf("%s: %w: %s is not a member of team %s", op, domain.ErrUserNotFound, userID, teamName)
+		}
+
+		openPRs := 0
+		for _, pr := range m.pullRequests {
+			if pr.status != handlers.PRStatusDraft && pr.status != handlers.PRStatusOpen {
+				continue
+			}
+			if pr.authorID == userID || slices.Contains(pr.reviewers, userID) {
+				openPRs++
+			}
+		}
+		if openPRs > 0 {
+			return handlers.Team{}, fmt.Errorf("%s: %w: %s has %d", op, domain.ErrUserHasOpenPRs, userID, openPRs)
+		}
+	}
+
+	for _, userID := range userIDs {
+		user := m.users[userID]
+		user.TeamName = ""
+		m.users[userID] = user
+		m.record(handlers.AuditEvent{Actor: actor, Action: handlers.AuditTeamMemberRemoved, UserID: userID, TeamName: teamName})
+	}
+
+	return m.team(teamName), nil
+}
+
+func (m *Memory) RenameTeam(ctx context.Context, actor, teamName, newName string) (handlers.Team, error) {
+	const op = "Storage.RenameTeam"
+
+	if err := ctx.Err(); err != nil {
+		return handlers.Team{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	settings, ok := m.teams[teamName]
+	if !ok {
+		return handlers.Team{}, fmt.Errorf("%s: %w", op, domain.ErrTeamNotFound)
+	}
+	if _, ok := m.teams[newName]; ok {
+		return handlers.Team{}, fmt.Errorf("%s: %w", op, domain.ErrTeamExists)
+	}
+
+	delete(m.teams, teamName)
+	m.teams[newName] = settings
+	for name, other := range m.teams {
+		if i := slices.Index(other.FallbackTeams, teamName); i >= 0 {
+			other.FallbackTeams = slices.Clone(other.FallbackTeams)
+			other.FallbackTeams[i] = newName
+			m.teams[name] = other
+		}
+	}
+	for id, user := range m.users {
+		if user.TeamName == teamName {
+			user.TeamName = newName
+			m.users[id] = user
+		}
+	}
+
+	m.record(handlers.AuditEvent{Actor: actor, Action: handlers.AuditTeamRenamed, TeamName: newName, Details: "renamed from " + teamName})
+
+	return m.team(newName), nil
+}
+
+func (m *Memory) DeleteTeam(ctx context.Context, actor, teamName string) error {
+	const op = "Storage.DeleteTeam"
+
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.teams[teamName]; !ok {
+		return fmt.Errorf("%s: %w", op, domain.ErrTeamNotFound)
+	}
+
+	members := 0
+	for _, user := range m.users {
+		if user.TeamName == teamName {
+			members++
+		}
+	}
+	if members > 0 {
+		return fmt.Errorf("%s: %w: %d members", op, domain.ErrTeamNotEmpty, members)
+	}
+
+	dependents := 0
+	for _, other := range m.teams {
+		if slices.Contains(other.FallbackTeams, teamName) {
+			dependents++
+		}
+	}
+	if dependents > 0 {
+		return fmt.Errorf("%s: %w: used by %d teams", op, domain.ErrTeamInUse, dependents)
+	}
+
+	delete(m.teams, teamName)
+	m.record(handlers.AuditEvent{Actor: actor, Action: handlers.AuditTeamDeleted, TeamName: teamName})
+
+	return nil
+}
+
+func (m *Memory) usernameOwner(username string) (string, bool) {
+	for id, user := range m.users {
+		if user.Username == username {
+			return id, true
+		}
+	}
+	return "", false
+}
+
+func (m *Memory) GetTeam(ctx context.Context, teamName string) (handlers.Team, error) {
+	const op = "Storage.GetTeam"
+
+	if err := ctx.Err(); err != nil {
+		return handlers.Team{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if _, ok := m.teams[teamName]; !ok {
+		return handlers.Team{}, fmt.Errorf("%s: %w", op, domain.ErrTeamNotFound)
+	}
+
+	return m.team(teamName), nil
+}
+
+// team returns an existing team with its members. The caller must hold m.mu.
+func (m *Memory) team(teamName string) handlers.Team {
+	members := make([]handlers.User, 0)
+	for _, id := range m.userOrder {
+		if user := m.users[id]; user.TeamName == teamName {
+			members = append(members, user)
+		}
+	}
+
+	settings := m.teams[teamName]
+	settings.FallbackTeams = slices.Clone(settings.FallbackTeams)
+
+	return handlers.Team{Name: teamName, Members: members, Settings: settings}
+}
+
+func (m *Memory) GetTeamSettings(ctx context.Context, teamName string) (*handlers.TeamSettings, error) {
+	const op = "Storage.GetTeamSettings"
+
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	settings, ok := m.teams[teamName]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", op, domain.ErrTeamNotFound)
+	}
+	settings.FallbackTeams = slices.Clone(settings.FallbackTeams)
+
+	return &settings, nil
+}
+
+// checkFallbackTeams mirrors the team_fallbacks foreign key. The caller must
+// hold m.mu.
+func (m *Memory) checkFallbackTeams(fallbacks []string) error {
+	for _, fallback := range fallbacks {
+		if _, ok := m.teams[fallback]; !ok {
+			return fmt.Errorf("%w: fallback team %s", domain.ErrTeamNotFound, fallback)
+		}
+	}
+	return nil
+}
+
+func (m *Memory) UpdateTeamSettings(ctx context.Context, actor, teamName string, settings handlers.TeamSettings) (*handlers.TeamSettings, error) {
+	const op = "Storage.UpdateTeamSettings"
+
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	settings, err := normalizeSettings(teamName, settings)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.teams[teamName]; !ok {
+		return nil, fmt.Errorf("%s: %w", op, domain.ErrTeamNotFound)
+	}
+	if err := m.checkFallbackTeams(settings.FallbackTeams); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	settings.FallbackTeams = slices.Clone(settings.FallbackTeams)
+	m.teams[teamName] = settings
+
+	m.record(handlers.AuditEvent{
+		Actor:    actor,
+		Action:   handlers.AuditTeamSettingsUpdated,
+		TeamName: teamName,
+		Details:  settingsDetails(settings),
+	})
+
+	return &settings, nil
+}
+
+func (m *Memory) SetUserIsActive(ctx context.Context, actor, userID string, isActive bool) (*handlers.User, *handlers.ReassignReport, error) {
+	const op = "Storage.SetUserIsActive"
+
+	if err := ctx.Err(); err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[userID]
+	if !ok {
+		return nil, nil, fmt.Errorf("%s: %w", op, domain.ErrUserNotFound)
+	}
+
+	user.IsActive = isActive
+	m.users[userID] = user
+
+	action := handlers.AuditUserDeactivated
+	if isActive {
+		action = handlers.AuditUserActivated
+	}
+	m.record(handlers.AuditEvent{Actor: actor, Action: action, UserID: userID, TeamName: user.TeamName})
+
+	var report *handlers.ReassignReport
+	if !isActive && m.reassignOnDeactivate {
+		var err error
+		if report, err = m.reassignReviews(actor, []string{userID}, ""); err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	return &user, report, nil
+}
+
+func (m *Memory) MoveUserToTeam(ctx context.Context, actor, userID, teamName string, handover bool) (*handlers.User, *handlers.ReassignReport, error) {
+	const op = "Storage.MoveUserToTeam"
+
+	if err := ctx.Err(); err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[userID]
+	if !ok {
+		return nil, nil, fmt.Errorf("%s: %w", op, domain.ErrUserNotFound)
+	}
+	if _, ok := m.teams[teamName]; !ok {
+		return nil, nil, fmt.Errorf("%s: %w", op, domain.ErrTeamNotFound)
+	}
+
+	oldTeam := user.TeamName
+	if oldTeam == teamName {
+		return &user, nil, nil
+	}
+
+	var report *handlers.ReassignReport
+	if handover && oldTeam != "" {
+		var err error
+		if report, err = m.reassignReviews(actor, []string{userID}, oldTeam); err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	user.TeamName = teamName
+	m.users[userID] = user
+
+	event := handlers.AuditEvent{Actor: actor, Action: handlers.AuditUserMoved, UserID: userID, TeamName: teamName}
+	if oldTeam != "" {
+		event.Details = "moved from " + oldTeam
+	}
+	m.record(event)
+
+	return &user, report, nil
+}
+
+func (m *Memory) DeactivateTeamUsers(ctx context.Context, actor, teamName string, userIDs []string, allExcept bool) (*handlers.TeamDeactivation, error) {
+	const op = "Storage.DeactivateTeamUsers"
+
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.teams[teamName]; !ok {
+		return nil, fmt.Errorf("%s: %w", op, domain.ErrTeamNotFound)
+	}
+
+	var memberIDs []string
+	for id, user := range m.users {
+		if user.TeamName == teamName {
+			memberIDs = append(memberIDs, id)
+		}
+	}
+	slices.Sort(memberIDs)
+
+	targets, err := deactivationTargets(teamName, memberIDs, userIDs, allExcept)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	result := &handlers.TeamDeactivation{TeamName: teamName, Deactivated: make([]string, 0)}
+	for _, id := range targets {
+		user := m.users[id]
+		if !user.IsActive {
+			continue
+		}
+		user.IsActive = false
+		m.users[id] = user
+		result.Deactivated = append(result.Deactivated, id)
+		m.record(handlers.AuditEvent{Actor: actor, Action: handlers.AuditUserDeactivated, UserID: id, TeamName: teamName})
+	}
+	report, err := m.reassignReviews(actor, targets, "")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	result.Reassignment = report
+
+	return result, nil
+}
+
+func (m *Memory) GetUser(ctx context.Context, userID string) (*handlers.User, error) {
+	const op = "Storage.GetUser"
+
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	user, ok := m.users[userID]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", op, domain.ErrUserNotFound)
+	}
+
+	return &user, nil
+}
+
+func (m *Memory) AddAbsence(ctx context.Context, actor string, absence handlers.Absence) (*handlers.Absence, error) {
+	const op = "Storage.AddAbsence"
+
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	absence, err := checkAbsence(absence)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[absence.UserID]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", op, domain.ErrUserNotFound)
+	}
+
+	m.absenceID++
+	absence.ID = m.absenceID
+	m.absences = append(m.absences, memAbsence{Absence: absence})
+
+	m.record(handlers.AuditEvent{
+		Actor:    actor,
+		Action:   handlers.AuditUserAbsenceAdded,
+		UserID:   absence.UserID,
+		TeamName: user.TeamName,
+		Details:  absenceDetails(absence),
+	})
+
+	return &absence, nil
+}
+
+func (m *Memory) GetAbsences(ctx context.Context, userID string) ([]handlers.Absence, error) {
+	const op = "Storage.GetAbsences"
+
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if _, ok := m.users[userID]; !ok {
+		return nil, fmt.Errorf("%s: %w", op, domain.ErrUserNotFound)
+	}
+
+	t := now()
+	absences := make([]handlers.Absence, 0)
+	for _, a := range m.absences {
+		if a.UserID == userID && a.End.After(t) {
+			absences = append(absences, a.Absence)
+		}
+	}
+	slices.SortStableFunc(absences, func(a, b handlers.Absence) int { return a.Start.Compare(b.Start) })
+
+	return absences, nil
+}
+
+func (m *Memory) DeleteAbsence(ctx context.Context, actor string, absenceID int64) error {
+	const op = "Storage.DeleteAbsence"
+
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i := slices.IndexFunc(m.absences, func(a memAbsence) bool { return a.ID == absenceID })
+	if i == -1 {
+		return fmt.Errorf("%s: %w", op, domain.ErrAbsenceNotFound)
+	}
+	absence := m.absences[i].Absence
+	m.absences = slices.Delete(m.absences, i, i+1)
+
+	m.record(handlers.AuditEvent{
+		Actor:    actor,
+		Action:   handlers.AuditUserAbsenceDeleted,
+		UserID:   absence.UserID,
+		TeamName: m.users[absence.UserID].TeamName,
+		Details:  absenceDetails(absence),
+	})
+
+	return nil
+}
+
+func (m *Memory) ReassignAbsentReviewers(ctx context.Context, actor string) (*handlers.ReassignReport, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t := now()
+	var userIDs []string
+	for i := range m.absences {
+		a := &m.absences[i]
+		if a.reassigned || a.Start.After(t) || !a.End.After(t) {
+			continue
+		}
+		a.reassigned = true
+		if !slices.Contains(userIDs, a.UserID) {
+			userIDs = append(userIDs, a.UserID)
+		}
+	}
+
+	return m.reassignReviews(actor, userIDs, "")
+}
+
+// absent tells whether userID is inside one of their absences at t. The
+// caller must hold m.mu.
+func (m *Memory) absent(userID string, t time.Time) bool {
+	return slices.ContainsFunc(m.absences, func(a memAbsence) bool {
+		return a.UserID == userID && !a.Start.After(t) && a.End.After(t)
+	})
+}
+
+func (m *Memory) CreatePullRequest(ctx context.Context, actor, prID, prName, authorID string, draft bool) (*handlers.PullRequest, error) {
+	const op = "Storage.CreatePullRequest"
+
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.pullRequests[prID]; ok {
+		return nil, fmt.Errorf("%s: %w", op, domain.ErrPRExists)
+	}
+
+	author, ok := m.users[authorID]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", op, domain.ErrAuthorNotFound)
+	}
+
+	createdAt := now()
+	pr := &memPullRequest{
+		id:         prID,
+		title:      prName,
+		authorID:   authorID,
+		status:     handlers.PRStatusOpen,
+		fallback:   make(map[string]bool),
+		states:     make(map[string]string),
+		assignedAt: make(map[string]time.Time),
+		createdAt:  createdAt,
+		updatedAt:  createdAt,
+	}
+	created := handlers.AuditEvent{
+		Actor:    actor,
+		Action:   handlers.AuditPRCreated,
+		PRID:     prID,
+		UserID:   authorID,
+		TeamName: author.TeamName,
+	}
+	if draft {
+		pr.status = handlers.PRStatusDraft
+		created.Details = "draft"
+		m.record(created)
+	} else {
+		// Events of a failed creation must not be recorded, so the created
+		// event goes first and is dropped with the rest on failure.
+		mark := len(m.audit)
+		m.record(created)
+		if err := m.assignReviewers(actor, pr); err != nil {
+			m.audit = m.audit[:mark]
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	m.pullRequests[prID] = pr
+	m.prOrder = append(m.prOrder, prID)
+
+	return pr.toPullRequest(), nil
+}
+
+// assignReviewers is the in-memory counterpart of DB.assignReviewers. The
+// caller must hold m.mu.
+func (m *Memory) assignReviewers(actor string, pr *memPullRequest) error {
+	teamName := m.users[pr.authorID].TeamName
+	settings, ok := m.teams[teamName]
+	if !ok {
+		return domain.ErrTeamNotFound
+	}
+
+	reviewers, fallback := m.selectReviewers(teamName, []string{pr.authorID}, settings.ReviewersPerPR)
+	if len(reviewers) < settings.MinReviewers {
+		return fmt.Errorf("%w: need %d, found %d", domain.ErrNotEnoughReviewers, settings.MinReviewers, len(reviewers))
+	}
+
+	assignedAt := now()
+	pr.reviewers = append(pr.reviewers, reviewers...)
+	for _, reviewerID := range reviewers {
+		pr.states[reviewerID] = handlers.ReviewPending
+		pr.assignedAt[reviewerID] = assignedAt
+	}
+	for _, reviewerID := range fallback {
+		pr.fallback[reviewerID] = true
+	}
+	for _, reviewerID := range reviewers {
+		event := handlers.AuditEvent{
+			Actor:         actor,
+			Action:        handlers.AuditReviewerAssigned,
+			PRID:          pr.id,
+			NewReviewerID: reviewerID,
+			TeamName:      teamName,
+		}
+		if pr.fallback[reviewerID] {
+			event.Details = "fallback"
+		}
+		m.record(event)
+	}
+
+	return nil
+}
+
+func (m *Memory) ReadyPullRequest(ctx context.Context, actor, prID string) (*handlers.PullRequest, error) {
+	const op = "Storage.ReadyPullRequest"
+
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pr, ok := m.pullRequests[prID]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", op, domain.ErrPRNotFound)
+	}
+
+	switch pr.status {
+	case handlers.PRStatusMerged:
+		return nil, fmt.Errorf("%s: %w", op, domain.ErrPRIsMerged)
+	case handlers.PRStatusClosed:
+		return nil, fmt.Errorf("%s: %w", op, domain.ErrPRClosed)
+	case handlers.PRStatusDraft:
+		if err := m.assignReviewers(actor, pr); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		m.recordTransition(actor, pr, handlers.PRStatusOpen, handlers.AuditPRReady)
+		pr.status = handlers.PRStatusOpen
+		pr.updatedAt = now()
+	}
+
+	return pr.toPullRequest(), nil
+}
+
+func (m *Memory) ClosePullRequest(ctx context.Context, actor, prID string) (*handlers.PullRequest, error) {
+	const op = "Storage.ClosePullRequest"
+
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pr, ok := m.pullRequests[prID]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", op, domain.ErrPRNotFound)
+	}
+
+	switch pr.status {
+	case handlers.PRStatusMerged:
+		return nil, fmt.Errorf("%s: %w", op, domain.ErrPRIsMerged)
+	case handlers.PRStatusDraft, handlers.PRStatusOpen:
+		m.recordTransition(actor, pr, handlers.PRStatusClosed, handlers.AuditPRClosed)
+		closedAt := now()
+		pr.status = handlers.PRStatusClosed
+		pr.updatedAt, pr.closedAt = closedAt, &closedAt
+	}
+
+	return pr.toPullRequest(), nil
+}
+
+func (m *Memory) ReopenPullRequest(ctx context.Context, actor, prID string) (*handlers.PullRequest, error) {
+	const op = "Storage.ReopenPullRequest"
+
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pr, ok := m.pullRequests[prID]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", op, domain.ErrPRNotFound)
+	}
+
+	switch pr.status {
+	case handlers.PRStatusMerged:
+		return nil, fmt.Errorf("%s: %w", op, domain.ErrPRIsMerged)
+	case handlers.PRStatusClosed:
+		if len(pr.reviewers) == 0 {
+			if err := m.assignReviewers(actor, pr); err != nil {
+				return nil, fmt.Errorf("%s: %w", op, err)
+			}
+		}
+		m.recordTransition(actor, pr, handlers.PRStatusOpen, handlers.AuditPRReopened)
+		pr.status = handlers.PRStatusOpen
+		pr.updatedAt, pr.closedAt = now(), nil
+	}
+
+	return pr.toPullRequest(), nil
+}
+
+func (m *Memory) GetPullRequest(ctx context.Context, prID string) (*handlers.PullRequest, error) {
+	const op = "Storage.GetPullRequest"
+
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	pr, ok := m.pullRequests[prID]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", op, domain.ErrPRNotFound)
+	}
+
+	return pr.toPullRequest(), nil
+}
+
+func (m *Memory) MergePullRequest(ctx context.Context, actor, prID string, force bool) (*handlers.PullRequest, bool, error) {
+	const op = "Storage.MergePullRequest"
+
+	if err := ctx.Err(); err != nil {
+		return nil, false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pr, ok := m.pullRequests[prID]
+	if !ok {
+		return nil, false, fmt.Errorf("%s: %w", op, domain.ErrPRNotFound)
+	}
+
+	if pr.status == handlers.PRStatusMerged {
+		return pr.toPullRequest(), false, nil
+	}
+	if err := requireOpen(pr.status, domain.ErrPRIsMerged); err != nil {
+		return nil, false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if !force {
+		team, ok := m.teams[m.users[pr.authorID].TeamName]
+		if !ok {
+			return nil, false, fmt.Errorf("%s: %w", op, domain.ErrTeamNotFound)
+		}
+		if unmet := unmetMergeConditions(team.MergePolicy, pr.toPullRequest().Reviews); len(unmet) > 0 {
+			return nil, false, fmt.Errorf("%s: %w", op, &domain.MergeBlockedError{Unmet: unmet})
+		}
+	}
+
+	mergedAt := now()
+	pr.status = handlers.PRStatusMerged
+	pr.updatedAt, pr.mergedAt = mergedAt, &mergedAt
+
+	event := handlers.AuditEvent{Actor: actor, Action: handlers.AuditPRMerged, PRID: prID}
+	if force {
+		event.Details = "forced"
+	}
+	m.record(event)
+
+	return pr.toPullRequest(), true, nil
+}
+
+func (m *Memory) ReassignReviewer(ctx context.Context, actor, prID, oldReviewerID string) (string, error) {
+	const op = "Storage.ReassignReviewer"
+
+	if err := ctx.Err(); err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pr, ok := m.pullRequests[prID]
+	if !ok {
+		return "", fmt.Errorf("%s: %w", op, domain.ErrPRNotFound)
+	}
+
+	if err := requireOpen(pr.status, domain.ErrPRMerged); err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	slot := -1
+	for i, reviewerID := range pr.reviewers {
+		if reviewerID == oldReviewerID {
+			slot = i
+			break
+		}
+	}
+	if slot == -1 {
+		return "", fmt.Errorf("%s: %w", op, domain.ErrNotAssigned)
+	}
+
+	newReviewerID, err := m.reassign(actor, pr, slot)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	return newReviewerID, nil
+}
+
+// reassign is the in-memory counterpart of DB.reassign; slot is the index of
+// the old reviewer in pr.reviewers. The caller must hold m.mu.
+func (m *Memory) reassign(actor string, pr *memPullRequest, slot int) (string, error) {
+	oldReviewerID := pr.reviewers[slot]
+	oldReviewer, ok := m.users[oldReviewerID]
+	if !ok {
+		return "", domain.ErrReviewerNotFound
+	}
+
+	exclude := append([]string{pr.authorID}, pr.reviewers...)
+
+	picked, fallback := m.selectReviewers(oldReviewer.TeamName, exclude, 1)
+	if len(picked) == 0 {
+		return "", domain.ErrNoCandidate
+	}
+
+	newReviewerID := picked[0]
+	pr.reviewers[slot] = newReviewerID
+	if len(fallback) > 0 || pr.fallback[oldReviewerID] {
+		pr.fallback[newReviewerID] = true
+	}
+	delete(pr.fallback, oldReviewerID)
+	delete(pr.states, oldReviewerID)
+	delete(pr.assignedAt, oldReviewerID)
+	pr.states[newReviewerID] = handlers.ReviewPending
+	pr.assignedAt[newReviewerID] = now()
+	pr.updatedAt = pr.assignedAt[newReviewerID]
+
+	event := handlers.AuditEvent{
+		Actor:         actor,
+		Action:        handlers.AuditReviewerReassigned,
+		PRID:          pr.id,
+		OldReviewerID: oldReviewerID,
+		NewReviewerID: newReviewerID,
+		TeamName:      oldReviewer.TeamName,
+	}
+	if pr.fallback[newReviewerID] {
+		event.Details = "fallback"
+	}
+	m.record(event)
+
+	return newReviewerID, nil
+}
+
+// reassignReviews is the in-memory counterpart of DB.reassignReviews. The
+// caller must hold m.mu.
+func (m *Memory) reassignReviews(actor string, userIDs []string, authorTeam string) (*handlers.ReassignReport, error) {
+	var reviews []handlers.Reassignment
+	for _, pr := range m.pullRequests {
+		if pr.status != handlers.PRStatusOpen {
+			continue
+		}
+		if authorTeam != "" && m.users[pr.authorID].TeamName != authorTeam {
+			continue
+		}
+		for _, reviewerID := range pr.reviewers {
+			if slices.Contains(userIDs, reviewerID) {
+				reviews = append(reviews, handlers.Reassignment{PRID: pr.id, OldReviewerID: reviewerID})
+			}
+		}
+	}
+	slices.SortFunc(reviews, func(a, b handlers.Reassignment) int {
+		return cmp.Or(cmp.Compare(a.PRID, b.PRID), cmp.Compare(a.OldReviewerID, b.OldReviewerID))
+	})
+
+	// Nothing rolls back a failed run here, so the errors that abort it
+	// are checked before any review moves.
+	for _, review := range reviews {
+		if _, ok := m.users[review.OldReviewerID]; !ok {
+			return nil, fmt.Errorf("%w: %s", domain.ErrReviewerNotFound, review.OldReviewerID)
+		}
+	}
+
+	report := newReassignReport()
+	for _, review := range reviews {
+		pr := m.pullRequests[review.PRID]
+		slot := slices.Index(pr.reviewers, review.OldReviewerID)
+
+		var err error
+		review.NewReviewerID, err = m.reassign(actor, pr, slot)
+		if err != nil {
+			if !errors.Is(err, domain.ErrNoCandidate) {
+				return nil, err
+			}
+			review.Reason = err.Error()
+			report.Failed = append(report.Failed, review)
+			continue
+		}
+		report.Reassigned = append(report.Reassigned, review)
+	}
+
+	return report, nil
+}
+
+func (m *Memory) SubmitReview(ctx context.Context, actor, prID, reviewerID, state string) (*handlers.PullRequest, error) {
+	const op = "Storage.SubmitReview"
+
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if !handlers.IsReviewState(state) || state == handlers.ReviewPending {
+		return nil, fmt.Errorf("%s: %w: %q", op, domain.ErrInvalidReviewState, state)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pr, ok := m.pullRequests[prID]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", op, domain.ErrPRNotFound)
+	}
+
+	if err := requireOpen(pr.status, domain.ErrReviewMerged); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if !slices.Contains(pr.reviewers, reviewerID) {
+		return nil, fmt.Errorf("%s: %w", op, domain.ErrNotAssigned)
+	}
+
+	pr.states[reviewerID] = state
+	pr.updatedAt = now()
+
+	m.record(handlers.AuditEvent{
+		Actor:   actor,
+		Action:  handlers.AuditReviewSubmitted,
+		PRID:    prID,
+		UserID:  reviewerID,
+		Details: state,
+	})
+
+	return pr.toPullRequest(), nil
+}
+
+func (m *Memory) GetPullRequestsByReviewer(ctx context.Context, userID string, filter handlers.ReviewFilter) ([]handlers.PullRequestShort, error) {
+	const op = "Storage.GetPullRequestsByReviewer"
+
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := validateReviewFilter(filter); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var prs []handlers.PullRequestShort
+	for _, id := range m.prOrder {
+		pr := m.pullRequests[id]
+		if !slices.Contains(pr.reviewers, userID) {
+			continue
+		}
+
+		state := pr.states[userID]
+		if len(filter.States) > 0 && !slices.Contains(filter.States, state) {
+			continue
+		}
+
+		full := pr.toPullRequest()
+		assignedAt := pr.assignedAt[userID]
+		prs = append(prs, handlers.PullRequestShort{
+			ID:          pr.id,
+			Name:        pr.title,
+			AuthorID:    pr.authorID,
+			Status:      pr.status,
+			ReviewState: state,
+			AssignedAt:  &assignedAt,
+			CreatedAt:   full.CreatedAt,
+			UpdatedAt:   full.UpdatedAt,
+			MergedAt:    full.MergedAt,
+			ClosedAt:    full.ClosedAt,
+		})
+	}
+
+	// prOrder is creation order, and IDs break ties like in DB.
+	switch filter.Sort {
+	case handlers.SortOldest:
+		slices.SortStableFunc(prs, func(a, b handlers.PullRequestShort) int {
+			return cmp.Or(a.CreatedAt.Compare(*b.CreatedAt), cmp.Compare(a.ID, b.ID))
+		})
+	case handlers.SortNewest:
+		slices.SortStableFunc(prs, func(a, b handlers.PullRequestShort) int {
+			return cmp.Or(b.CreatedAt.Compare(*a.CreatedAt), cmp.Compare(a.ID, b.ID))
+		})
+	}
+
+	return prs, nil
+}
+
+// selectReviewers is the in-memory counterpart of DB.selectReviewers. The
+// caller must hold m.mu.
+func (m *Memory) selectReviewers(teamName string, exclude []string, n int) (picked, fallback []string) {
+	settings := m.teams[teamName]
+	picked = m.selectors.Select(settings.ReviewerStrategy, teamName, m.candidates(teamName, exclude), n)
+
+	for _, fallbackTeam := range settings.FallbackTeams {
+		if len(picked) >= n {
+			break
+		}
+
+		candidates := m.candidates(fallbackTeam, slices.Concat(exclude, picked))
+		extra := m.selectors.Select(m.teams[fallbackTeam].ReviewerStrategy, fallbackTeam, candidates, n-len(picked))
+		picked = append(picked, extra...)
+		fallback = append(fallback, extra...)
+	}
+
+	return picked, fallback
+}
+
+func (m *Memory) CountOpenReviewsByTeam(ctx context.Context) (map[string]int, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	counts := make(map[string]int)
+	for _, pr := range m.pullRequests {
+		if pr.status != handlers.PRStatusOpen {
+			continue
+		}
+		for _, reviewerID := range pr.reviewers {
+			counts[m.users[reviewerID].TeamName]++
+		}
+	}
+
+	return counts, nil
+}
+
+// candidates is the in-memory counterpart of loadCandidates. The caller must
+// hold m.mu.
+func (m *Memory) candidates(teamName string, exclude []string) []reviewer.Candidate {
+	skip := make(map[string]struct{}, len(exclude))
+	for _, userID := range exclude {
+		skip[userID] = struct{}{}
+	}
+
+	openReviews := make(map[string]int)
+	for _, pr := range m.pullRequests {
+		if pr.status != handlers.PRStatusOpen {
+			continue
+		}
+		for _, reviewerID := range pr.reviewers {
+			openReviews[reviewerID]++
+		}
+	}
+
+	t := now()
+	var candidates []reviewer.Candidate
+	for _, id := range m.userOrder {
+		user := m.users[id]
+		if _, ok := skip[user.ID]; ok {
+			continue
+		}
+		if user.TeamName == teamName && user.IsActive && !m.absent(user.ID, t) {
+			candidates = append(candidates, reviewer.Candidate{
+				UserID:         user.ID,
+				OpenReviews:    openReviews[user.ID],
+				Weight:         user.ReviewWeight,
+				MaxOpenReviews: user.MaxOpenReviews,
+			})
+		}
+	}
+
+	return candidates
+}
+
+// record appends e to the audit log. The caller must hold m.mu.
+func (m *Memory) record(e handlers.AuditEvent) {
+	e.ID = int64(len(m.audit)) + 1
+	e.CreatedAt = now()
+	m.audit = append(m.audit, e)
+}
+
+// recordTransition records pr moving to status. The caller must hold m.mu.
+func (m *Memory) recordTransition(actor string, pr *memPullRequest, status, action string) {
+	m.record(handlers.AuditEvent{Actor: actor, Action: action, PRID: pr.id, Details: pr.status + " -> " + status})
+}
+
+func (m *Memory) GetAuditEvents(ctx context.Context, filter handlers.AuditFilter) (*handlers.AuditPage, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	events := make([]handlers.AuditEvent, 0)
+	for _, e := range m.audit {
+		if e.ID <= filter.After {
+			continue
+		}
+		if filter.PRID != "" && e.PRID != filter.PRID {
+			continue
+		}
+		if filter.UserID != "" && e.UserID != filter.UserID &&
+			e.OldReviewerID != filter.UserID && e.NewReviewerID != filter.UserID {
+			continue
+		}
+		if !filter.Since.IsZero() && e.CreatedAt.Before(filter.Since) {
+			continue
+		}
+
+		events = append(events, e)
+		if len(events) > filter.Limit {
+			break
+		}
+	}
+
+	return auditPage(events, filter.Limit), nil
+}
+
+func (m *Memory) Ping(ctx context.Context) error {
+	return ctx.Err()
+}
+
+// MigrationState is nil: in-memory storage has no schema to migrate.
+func (m *Memory) MigrationState(ctx context.Context) (*handlers.MigrationState, error) {
+	return nil, nil
+}
+
+func (m *Memory) Close() error {
+	m.log.Info("in-memory storage closed")
+	return nil
+}
+
+func (pr *memPullRequest) toPullRequest() *handlers.PullRequest {
+	var reviewers, fallback []string
+	reviewers = append(reviewers, pr.reviewers...)
+	reviews := make([]handlers.Review, 0, len(pr.reviewers))
+	for _, reviewerID := range pr.reviewers {
+		if pr.fallback[reviewerID] {
+			fallback = append(fallback, reviewerID)
+		}
+		assignedAt := pr.assignedAt[reviewerID]
+		reviews = append(reviews, handlers.Review{ReviewerID: reviewerID, State: pr.states[reviewerID], AssignedAt: &assignedAt})
+	}
+
+	createdAt, updatedAt := pr.createdAt, pr.updatedAt
+
+	return &handlers.PullRequest{
+		ID:                pr.id,
+		Name:              pr.title,
+		AuthorID:          pr.authorID,
+		Status:            pr.status,
+		AssignedReviewers: reviewers,
+		FallbackReviewers: fallback,
+		Reviews:           reviews,
+		CreatedAt:         &createdAt,
+		UpdatedAt:         &updatedAt,
+		MergedAt:          copyTime(pr.mergedAt),
+		ClosedAt:          copyTime(pr.closedAt),
+	}
+}
+
+func copyTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	c := *t
+	return &c
+}
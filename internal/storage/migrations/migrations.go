@@ -0,0 +1,445 @@
+// Package migrations applies the versioned SQL schema embedded into the
+// binary. Every driver has its own directory of NNNN_name.up.sql and
+// NNNN_name.down.sql files; applied versions are tracked in schema_migrations.
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/ten00m/golang-test-task/internal/config"
+)
+
+//go:embed postgres/*.sql sqlite/*.sql
+var files embed.FS
+
+// lockKey identifies the Postgres advisory lock held while migrating.
+const lockKey = 7_202_511
+
+var fileNameRe = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+type Status struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	Applied   bool       `json:"applied"`
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+}
+
+// execer is implemented by both *sql.Conn and *sql.Tx.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+type Migrator struct {
+	conn       *sql.DB
+	driver     string
+	log        *slog.Logger
+	migrations []Migration
+}
+
+// New returns a Migrator for the embedded migrations of driver, which is one
+// of config.DriverPostgres or config.DriverSQLite.
+func New(conn *sql.DB, driver string, log *slog.Logger) (*Migrator, error) {
+	const op = "migrations.New"
+
+	if driver != config.DriverPostgres && driver != config.DriverSQLite {
+		return nil, fmt.Errorf("%s: no migrations for driver %q", op, driver)
+	}
+
+	migrations, err := load(driver)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return &Migrator{
+		conn:       conn,
+		driver:     driver,
+		log:        log.With(slog.String("component", "migrations")),
+		migrations: migrations,
+	}, nil
+}
+
+func load(driver string) ([]Migration, error) {
+	entries, err := fs.ReadDir(files, driver)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		m := fileNameRe.FindStringSubmatch(entry.Name())
+		if m == nil {
+			return nil, fmt.Errorf("unexpected migration file %s", entry.Name())
+		}
+
+		version, _ := strconv.Atoi(m[1])
+		body, err := fs.ReadFile(files, path.Join(driver, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = mig
+		}
+		if mig.Name != m[2] {
+			return nil, fmt.Errorf("migration %d has conflicting names %q and %q", version, mig.Name, m[2])
+		}
+
+		if m[3] == "up" {
+			mig.Up = string(body)
+		} else {
+			mig.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		if mig.Up == "" || mig.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s must have both up and down steps", mig.Version, mig.Name)
+		}
+		migrations = append(migrations, *mig)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+// Latest returns the highest known migration version.
+func (m *Migrator) Latest() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// State returns the highest applied version and the number of known
+// migrations not applied yet. Unlike Status it takes no lock, so it is
+// cheap enough for health checks, but it may lag behind a migration that
+// is running in another process.
+func (m *Migrator) State(ctx context.Context) (current, pending int, err error) {
+	const op = "migrations.State"
+
+	rows, err := m.conn.QueryContext(ctx, `SELECT version FROM schema_migrations`)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]bool)
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			return 0, 0, fmt.Errorf("%s: %w", op, err)
+		}
+		applied[version] = true
+		current = max(current, version)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	for _, mig := range m.migrations {
+		if !applied[mig.Version] {
+			pending++
+		}
+	}
+
+	return current, pending, nil
+}
+
+// Up applies every pending migration.
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.To(ctx, m.Latest())
+}
+
+// Down reverts the steps most recently applied migrations.
+func (m *Migrator) Down(ctx context.Context, steps int) error {
+	const op = "migrations.Down"
+
+	return m.withLock(ctx, func(step stepFunc) error {
+		applied, err := m.applied(ctx, step)
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+
+		versions := sortedVersions(applied)
+		for i := len(versions) - 1; i >= 0 && steps > 0; i, steps = i-1, steps-1 {
+			if err := m.revert(ctx, step, versions[i]); err != nil {
+				return fmt.Errorf("%s: %w", op, err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// To migrates up or down until exactly the migrations with version <= target
+// are applied. To(ctx, 0) reverts everything.
+func (m *Migrator) To(ctx context.Context, target int) error {
+	const op = "migrations.To"
+
+	if target < 0 || target > m.Latest() {
+		return fmt.Errorf("%s: unknown version %d (latest is %d)", op, target, m.Latest())
+	}
+
+	return m.withLock(ctx, func(step stepFunc) error {
+		applied, err := m.applied(ctx, step)
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+
+		versions := sortedVersions(applied)
+		for i := len(versions) - 1; i >= 0 && versions[i] > target; i-- {
+			if err := m.revert(ctx, step, versions[i]); err != nil {
+				return fmt.Errorf("%s: %w", op, err)
+			}
+		}
+
+		for _, mig := range m.migrations {
+			if mig.Version > target {
+				break
+			}
+			if _, ok := applied[mig.Version]; ok {
+				continue
+			}
+			if err := m.apply(ctx, step, mig); err != nil {
+				return fmt.Errorf("%s: %w", op, err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// Status reports every known migration and whether it has been applied.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	const op = "migrations.Status"
+
+	var statuses []Status
+	err := m.withLock(ctx, func(step stepFunc) error {
+		applied, err := m.applied(ctx, step)
+		if err != nil {
+			return err
+		}
+
+		for _, mig := range m.migrations {
+			st := Status{Version: mig.Version, Name: mig.Name}
+			if at, ok := applied[mig.Version]; ok {
+				st.Applied = true
+				st.AppliedAt = &at
+			}
+			statuses = append(statuses, st)
+			delete(applied, mig.Version)
+		}
+
+		for version, at := range applied {
+			statuses = append(statuses, Status{Version: version, Name: "unknown", Applied: true, AppliedAt: &at})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return statuses, nil
+}
+
+func (m *Migrator) apply(ctx context.Context, step stepFunc, mig Migration) error {
+	m.log.Info("applying migration", slog.Int("version", mig.Version), slog.String("name", mig.Name))
+
+	return step(func(ex execer) error {
+		if _, err := ex.ExecContext(ctx, mig.Up); err != nil {
+			return fmt.Errorf("apply %d_%s: %w", mig.Version, mig.Name, err)
+		}
+
+		_, err := ex.ExecContext(ctx, `INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)`,
+			mig.Version, mig.Name, time.Now().UTC())
+		if err != nil {
+			return fmt.Errorf("record %d_%s: %w", mig.Version, mig.Name, err)
+		}
+
+		return nil
+	})
+}
+
+func (m *Migrator) revert(ctx context.Context, step stepFunc, version int) error {
+	var mig *Migration
+	for i := range m.migrations {
+		if m.migrations[i].Version == version {
+			mig = &m.migrations[i]
+			break
+		}
+	}
+	if mig == nil {
+		return fmt.Errorf("applied migration %d is unknown to this binary", version)
+	}
+
+	m.log.Info("reverting migration", slog.Int("version", mig.Version), slog.String("name", mig.Name))
+
+	return step(func(ex execer) error {
+		if _, err := ex.ExecContext(ctx, mig.Down); err != nil {
+			return fmt.Errorf("revert %d_%s: %w", mig.Version, mig.Name, err)
+		}
+
+		if _, err := ex.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = $1`, mig.Version); err != nil {
+			return fmt.Errorf("unrecord %d_%s: %w", mig.Version, mig.Name, err)
+		}
+
+		return nil
+	})
+}
+
+func (m *Migrator) applied(ctx context.Context, step stepFunc) (map[int]time.Time, error) {
+	applied := make(map[int]time.Time)
+
+	err := step(func(ex execer) error {
+		if _, err := ex.ExecContext(ctx, m.createTableQuery()); err != nil {
+			return err
+		}
+
+		rows, err := ex.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var version int
+			var at time.Time
+			if err := rows.Scan(&version, &at); err != nil {
+				return err
+			}
+			applied[version] = at
+		}
+
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, fmt.Errorf("read schema_migrations: %w", err)
+	}
+
+	return applied, nil
+}
+
+func (m *Migrator) createTableQuery() string {
+	if m.driver == config.DriverSQLite {
+		return `CREATE TABLE IF NOT EXISTS schema_migrations(
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at DATETIME NOT NULL
+		)`
+	}
+
+	return `CREATE TABLE IF NOT EXISTS schema_migrations(
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL
+	)`
+}
+
+// stepFunc runs fn as one atomic migration step.
+type stepFunc func(fn func(ex execer) error) error
+
+// withLock makes sure only one process migrates the database at a time.
+//
+// On Postgres a session-level advisory lock is held for the whole run and
+// every step gets its own transaction. On SQLite the database write lock is
+// the only cross-process lock available, so the whole run is a single
+// immediate transaction; foreign keys are switched off around it because
+// SQLite can only change constraints by rebuilding tables.
+func (m *Migrator) withLock(ctx context.Context, fn func(step stepFunc) error) error {
+	conn, err := m.conn.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("acquire connection: %w", err)
+	}
+	defer conn.Close()
+
+	if m.driver == config.DriverSQLite {
+		return m.withSQLiteLock(ctx, conn, fn)
+	}
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, lockKey); err != nil {
+		return fmt.Errorf("acquire migration lock: %w", err)
+	}
+	defer func() {
+		if _, err := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, lockKey); err != nil {
+			m.log.Error("failed to release migration lock", slog.String("error", err.Error()))
+		}
+	}()
+
+	return fn(func(step func(ex execer) error) error {
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+
+		if err := step(tx); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+
+		return tx.Commit()
+	})
+}
+
+func (m *Migrator) withSQLiteLock(ctx context.Context, conn *sql.Conn, fn func(step stepFunc) error) error {
+	if _, err := conn.ExecContext(ctx, `PRAGMA foreign_keys = OFF`); err != nil {
+		return fmt.Errorf("disable foreign keys: %w", err)
+	}
+	defer func() {
+		if _, err := conn.ExecContext(context.Background(), `PRAGMA foreign_keys = ON`); err != nil {
+			m.log.Error("failed to re-enable foreign keys", slog.String("error", err.Error()))
+		}
+	}()
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("acquire migration lock: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := fn(func(step func(ex execer) error) error { return step(tx) }); err != nil {
+		return err
+	}
+
+	var violation string
+	err = tx.QueryRowContext(ctx, `SELECT "table" FROM pragma_foreign_key_check`).Scan(&violation)
+	if err == nil {
+		return fmt.Errorf("migration leaves foreign key violations in table %s", violation)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("check foreign keys: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+func sortedVersions(applied map[int]time.Time) []int {
+	versions := make([]int, 0, len(applied))
+	for version := range applied {
+		versions = append(versions, version)
+	}
+	sort.Ints(versions)
+	return versions
+}
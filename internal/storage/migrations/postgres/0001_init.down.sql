@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS pr_fk_reviewer;
+DROP TABLE IF EXISTS team_fk_user;
+DROP TABLE IF EXISTS pull_requests;
+DROP TABLE IF EXISTS users;
+DROP TABLE IF EXISTS teams;
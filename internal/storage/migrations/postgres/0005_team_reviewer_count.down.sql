@@ -0,0 +1,2 @@
+ALTER TABLE teams DROP COLUMN min_reviewers;
+ALTER TABLE teams DROP COLUMN reviewers_per_pr;
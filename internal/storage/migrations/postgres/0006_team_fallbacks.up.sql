@@ -0,0 +1,11 @@
+CREATE TABLE team_fallbacks(
+    team_name TEXT NOT NULL,
+    fallback_team TEXT NOT NULL,
+    position INTEGER NOT NULL,
+    PRIMARY KEY (team_name, fallback_team),
+    FOREIGN KEY (team_name) REFERENCES teams(name),
+    FOREIGN KEY (fallback_team) REFERENCES teams(name),
+    CHECK (team_name <> fallback_team)
+);
+
+ALTER TABLE pr_fk_reviewer ADD COLUMN fallback BOOLEAN NOT NULL DEFAULT false;
@@ -0,0 +1,3 @@
+ALTER TABLE teams DROP COLUMN require_all_reviewers;
+ALTER TABLE teams DROP COLUMN block_on_changes_requested;
+ALTER TABLE teams DROP COLUMN required_approvals;
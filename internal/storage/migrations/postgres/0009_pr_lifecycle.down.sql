@@ -0,0 +1,8 @@
+-- DRAFT and CLOSED cannot be represented before this migration: drafts
+-- become OPEN and closed PRs, which are final too, become MERGED.
+UPDATE pull_requests SET status = 'OPEN' WHERE status = 'DRAFT';
+UPDATE pull_requests SET status = 'MERGED' WHERE status = 'CLOSED';
+
+ALTER TABLE pull_requests DROP CONSTRAINT pull_requests_status_check;
+ALTER TABLE pull_requests ADD CONSTRAINT pull_requests_status_check
+    CHECK (status IN ('OPEN', 'MERGED'));
@@ -0,0 +1,6 @@
+ALTER TABLE pr_fk_reviewer DROP COLUMN assigned_at;
+
+ALTER TABLE pull_requests DROP COLUMN closed_at;
+ALTER TABLE pull_requests DROP COLUMN merged_at;
+ALTER TABLE pull_requests DROP COLUMN updated_at;
+ALTER TABLE pull_requests DROP COLUMN created_at;
@@ -0,0 +1,8 @@
+-- Rows created before this migration keep NULL timestamps: when they
+-- happened is unknown.
+ALTER TABLE pull_requests ADD COLUMN created_at TIMESTAMPTZ;
+ALTER TABLE pull_requests ADD COLUMN updated_at TIMESTAMPTZ;
+ALTER TABLE pull_requests ADD COLUMN merged_at TIMESTAMPTZ;
+ALTER TABLE pull_requests ADD COLUMN closed_at TIMESTAMPTZ;
+
+ALTER TABLE pr_fk_reviewer ADD COLUMN assigned_at TIMESTAMPTZ;
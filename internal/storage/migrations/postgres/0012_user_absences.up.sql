@@ -0,0 +1,14 @@
+-- Windows in which a user is away and must not be picked as a reviewer.
+-- reassigned_at is set once the absence worker has handed the user's open
+-- reviews over, so every absence is handled once.
+CREATE TABLE user_absences(
+    id BIGSERIAL PRIMARY KEY,
+    user_id TEXT NOT NULL REFERENCES users(id),
+    starts_at TIMESTAMPTZ NOT NULL,
+    ends_at TIMESTAMPTZ NOT NULL,
+    reason TEXT,
+    reassigned_at TIMESTAMPTZ,
+    CHECK (ends_at > starts_at)
+);
+
+CREATE INDEX user_absences_user_id_idx ON user_absences (user_id, ends_at);
@@ -0,0 +1,37 @@
+-- Baseline schema. IF NOT EXISTS keeps this a no-op on databases that were
+-- created before migrations were introduced.
+CREATE TABLE IF NOT EXISTS teams(
+    name TEXT PRIMARY KEY
+);
+
+CREATE TABLE IF NOT EXISTS users(
+    id TEXT PRIMARY KEY,
+    username TEXT NOT NULL UNIQUE,
+    is_active BOOLEAN NOT NULL,
+    team_name TEXT,
+    FOREIGN KEY (team_name) REFERENCES teams(name)
+);
+
+CREATE TABLE IF NOT EXISTS pull_requests(
+    id TEXT PRIMARY KEY,
+    title TEXT NOT NULL,
+    authorId TEXT NOT NULL,
+    status VARCHAR(10) NOT NULL CHECK (status IN ('OPEN', 'MERGED')),
+    FOREIGN KEY (authorId) REFERENCES users(id)
+);
+
+CREATE TABLE IF NOT EXISTS team_fk_user(
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    team_name TEXT NOT NULL,
+    user_id TEXT NOT NULL,
+    FOREIGN KEY (team_name) REFERENCES teams(name),
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE TABLE IF NOT EXISTS pr_fk_reviewer(
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    pr_id TEXT NOT NULL,
+    user_id TEXT NOT NULL,
+    FOREIGN KEY (pr_id) REFERENCES pull_requests(id),
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS pr_fk_reviewer_pr_id_user_id_key;
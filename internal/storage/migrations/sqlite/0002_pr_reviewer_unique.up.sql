@@ -0,0 +1 @@
+CREATE UNIQUE INDEX IF NOT EXISTS pr_fk_reviewer_pr_id_user_id_key ON pr_fk_reviewer (pr_id, user_id);
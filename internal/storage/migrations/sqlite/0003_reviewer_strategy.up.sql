@@ -0,0 +1,2 @@
+ALTER TABLE teams ADD COLUMN reviewer_strategy TEXT;
+ALTER TABLE users ADD COLUMN review_weight INTEGER NOT NULL DEFAULT 1 CHECK (review_weight > 0);
@@ -0,0 +1,2 @@
+ALTER TABLE teams ADD COLUMN reviewers_per_pr INTEGER NOT NULL DEFAULT 2 CHECK (reviewers_per_pr > 0);
+ALTER TABLE teams ADD COLUMN min_reviewers INTEGER NOT NULL DEFAULT 0 CHECK (min_reviewers >= 0);
@@ -0,0 +1,3 @@
+ALTER TABLE pr_fk_reviewer DROP COLUMN fallback;
+
+DROP TABLE team_fallbacks;
@@ -0,0 +1 @@
+ALTER TABLE pr_fk_reviewer DROP COLUMN state;
@@ -0,0 +1,2 @@
+ALTER TABLE pr_fk_reviewer ADD COLUMN state VARCHAR(20) NOT NULL DEFAULT 'PENDING'
+    CHECK (state IN ('PENDING', 'APPROVED', 'CHANGES_REQUESTED', 'COMMENTED'));
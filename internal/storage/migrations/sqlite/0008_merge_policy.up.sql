@@ -0,0 +1,3 @@
+ALTER TABLE teams ADD COLUMN required_approvals INTEGER NOT NULL DEFAULT 0 CHECK (required_approvals >= 0);
+ALTER TABLE teams ADD COLUMN block_on_changes_requested BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE teams ADD COLUMN require_all_reviewers BOOLEAN NOT NULL DEFAULT false;
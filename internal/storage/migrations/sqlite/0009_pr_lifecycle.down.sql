@@ -0,0 +1,17 @@
+-- DRAFT and CLOSED cannot be represented before this migration: drafts
+-- become OPEN and closed PRs, which are final too, become MERGED.
+CREATE TABLE pull_requests_old(
+    id TEXT PRIMARY KEY,
+    title TEXT NOT NULL,
+    authorId TEXT NOT NULL,
+    status VARCHAR(10) NOT NULL CHECK (status IN ('OPEN', 'MERGED')),
+    FOREIGN KEY (authorId) REFERENCES users(id)
+);
+
+INSERT INTO pull_requests_old (id, title, authorId, status)
+SELECT id, title, authorId,
+    CASE status WHEN 'DRAFT' THEN 'OPEN' WHEN 'CLOSED' THEN 'MERGED' ELSE status END
+FROM pull_requests;
+
+DROP TABLE pull_requests;
+ALTER TABLE pull_requests_old RENAME TO pull_requests;
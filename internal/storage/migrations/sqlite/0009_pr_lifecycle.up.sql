@@ -0,0 +1,16 @@
+-- SQLite cannot alter a CHECK constraint, so the table is rebuilt. The
+-- migrator turns foreign keys off around this, which keeps pr_fk_reviewer
+-- pointing at the new table.
+CREATE TABLE pull_requests_new(
+    id TEXT PRIMARY KEY,
+    title TEXT NOT NULL,
+    authorId TEXT NOT NULL,
+    status VARCHAR(10) NOT NULL CHECK (status IN ('DRAFT', 'OPEN', 'MERGED', 'CLOSED')),
+    FOREIGN KEY (authorId) REFERENCES users(id)
+);
+
+INSERT INTO pull_requests_new (id, title, authorId, status)
+SELECT id, title, authorId, status FROM pull_requests;
+
+DROP TABLE pull_requests;
+ALTER TABLE pull_requests_new RENAME TO pull_requests;
@@ -0,0 +1,19 @@
+-- Append-only log of storage mutations. It has no foreign keys so events
+-- outlive the teams, users and PRs they mention.
+CREATE TABLE audit_events(
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    created_at TIMESTAMP NOT NULL,
+    actor TEXT,
+    action TEXT NOT NULL,
+    pr_id TEXT,
+    user_id TEXT,
+    old_reviewer_id TEXT,
+    new_reviewer_id TEXT,
+    team_name TEXT,
+    details TEXT
+);
+
+CREATE INDEX audit_events_pr_id_idx ON audit_events (pr_id);
+CREATE INDEX audit_events_user_id_idx ON audit_events (user_id);
+CREATE INDEX audit_events_old_reviewer_id_idx ON audit_events (old_reviewer_id);
+CREATE INDEX audit_events_new_reviewer_id_idx ON audit_events (new_reviewer_id);
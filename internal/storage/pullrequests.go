@@ -1,220 +1,412 @@
 package storage
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	"math/rand"
-	"time"
+	"slices"
 
+	"github.com/ten00m/golang-test-task/internal/domain"
 	"github.com/ten00m/golang-test-task/internal/http-server/handlers"
 )
 
-func (db *DB) CreatePullRequest(prID, prName, authorID string) (*handlers.PullRequest, error) {
+// CreatePullRequest inserts the PR and its reviewers in a single transaction,
+// so concurrent creates with the same ID or a failure halfway through never
+// leave a PR without its reviewers. Drafts get no reviewers until they are
+// marked ready.
+func (db *DB) CreatePullRequest(ctx context.Context, actor, prID, prName, authorID string, draft bool) (*handlers.PullRequest, error) {
 	const op = "Storage.CreatePullRequest"
 
-	var existingID string
-	err := db.conn.QueryRow(`SELECT id FROM pull_requests WHERE id = $1`, prID).Scan(&existingID)
-	if err == nil {
-		return nil, fmt.Errorf("%s: PR already exists", op)
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	status := handlers.PRStatusOpen
+	if draft {
+		status = handlers.PRStatusDraft
 	}
-	if err != sql.ErrNoRows {
+
+	var pr *handlers.PullRequest
+	err := db.withTx(ctx, func(tx *sql.Tx) error {
+		var existingID string
+		err := tx.QueryRowContext(ctx, `SELECT id FROM pull_requests WHERE id = $1`, prID).Scan(&existingID)
+		if err == nil {
+			return domain.ErrPRExists
+		}
+		if err != sql.ErrNoRows {
+			return err
+		}
+
+		var teamName string
+		err = tx.QueryRowContext(ctx, `SELECT COALESCE(team_name, '') FROM users WHERE id = $1`, authorID).Scan(&teamName)
+		if err == sql.ErrNoRows {
+			return domain.ErrAuthorNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		createdAt := now()
+		_, err = tx.ExecContext(ctx, `INSERT INTO pull_requests (id, title, authorId, status, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $5)`,
+			prID, prName, authorID, status, createdAt)
+		if isUniqueViolation(err) {
+			return domain.ErrPRExists
+		}
+		if err != nil {
+			return err
+		}
+
+		event := handlers.AuditEvent{
+			Actor:    actor,
+			Action:   handlers.AuditPRCreated,
+			PRID:     prID,
+			UserID:   authorID,
+			TeamName: teamName,
+		}
+		if draft {
+			event.Details = "draft"
+		}
+		if err := recordAudit(ctx, tx, event); err != nil {
+			return err
+		}
+
+		if !draft {
+			if err := db.assignReviewers(ctx, tx, actor, prID, authorID); err != nil {
+				return err
+			}
+		}
+
+		pr, err = getPullRequest(ctx, tx, prID)
+		return err
+	})
+	if err != nil {
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 
+	return pr, nil
+}
+
+// assignReviewers picks reviewers for a PR without any from the team of its
+// author, failing if the team's min_reviewers cannot be met.
+func (db *DB) assignReviewers(ctx context.Context, tx *sql.Tx, actor, prID, authorID string) error {
 	var teamName string
-	var isActive bool
-	err = db.conn.QueryRow(`SELECT team_name, is_active FROM users WHERE id = $1`, authorID).Scan(&teamName, &isActive)
+	err := tx.QueryRowContext(ctx, `SELECT COALESCE(team_name, '') FROM users WHERE id = $1`, authorID).Scan(&teamName)
 	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("%s: author not found", op)
+		return domain.ErrAuthorNotFound
 	}
 	if err != nil {
-		return nil, fmt.Errorf("%s: %w", op, err)
+		return err
 	}
 
-	_, err = db.conn.Exec(`INSERT INTO pull_requests (id, title, authorId, status) VALUES ($1, $2, $3, 'OPEN')`,
-		prID, prName, authorID)
+	settings, err := getTeamSettings(ctx, tx, teamName)
 	if err != nil {
-		return nil, fmt.Errorf("%s: %w", op, err)
+		return err
 	}
 
-	rows, err := db.conn.Query(`
-		SELECT id FROM users 
-		WHERE team_name = $1 AND is_active = true AND id != $2
-	`, teamName, authorID)
+	reviewers, fallback, err := db.selectReviewers(ctx, tx, teamName, settings, []string{authorID}, settings.ReviewersPerPR)
 	if err != nil {
-		return nil, fmt.Errorf("%s: %w", op, err)
+		return err
 	}
-	defer rows.Close()
-
-	var candidates []string
-	for rows.Next() {
-		var userID string
-		if err := rows.Scan(&userID); err != nil {
-			return nil, fmt.Errorf("%s: %w", op, err)
-		}
-		candidates = append(candidates, userID)
+	if len(reviewers) < settings.MinReviewers {
+		return fmt.Errorf("%w: need %d, found %d", domain.ErrNotEnoughReviewers, settings.MinReviewers, len(reviewers))
 	}
 
-	reviewers := selectRandomReviewers(candidates, 2)
-
+	assignedAt := now()
 	for _, reviewerID := range reviewers {
-		_, err := db.conn.Exec(`INSERT INTO pr_fk_reviewer (pr_id, user_id) VALUES ($1, $2)`, prID, reviewerID)
+		_, err := tx.ExecContext(ctx, `INSERT INTO pr_fk_reviewer (pr_id, user_id, fallback, assigned_at) VALUES ($1, $2, $3, $4)`,
+			prID, reviewerID, slices.Contains(fallback, reviewerID), assignedAt)
 		if err != nil {
-			return nil, fmt.Errorf("%s: failed to add reviewer: %w", op, err)
+			return fmt.Errorf("failed to add reviewer: %w", err)
+		}
+
+		event := handlers.AuditEvent{
+			Actor:         actor,
+			Action:        handlers.AuditReviewerAssigned,
+			PRID:          prID,
+			NewReviewerID: reviewerID,
+			TeamName:      teamName,
+		}
+		if slices.Contains(fallback, reviewerID) {
+			event.Details = "fallback"
+		}
+		if err := recordAudit(ctx, tx, event); err != nil {
+			return err
 		}
 	}
 
-	return &handlers.PullRequest{
-		ID:                prID,
-		Name:              prName,
-		AuthorID:          authorID,
-		Status:            "OPEN",
-		AssignedReviewers: reviewers,
-	}, nil
+	return nil
 }
 
-func (db *DB) GetPullRequest(prID string) (*handlers.PullRequest, error) {
+func (db *DB) GetPullRequest(ctx context.Context, prID string) (*handlers.PullRequest, error) {
 	const op = "Storage.GetPullRequest"
 
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	pr, err := getPullRequest(ctx, db.conn, prID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return pr, nil
+}
+
+func getPullRequest(ctx context.Context, q queryer, prID string) (*handlers.PullRequest, error) {
 	var pr handlers.PullRequest
-	err := db.conn.QueryRow(`SELECT id, title, authorId, status FROM pull_requests WHERE id = $1`, prID).
-		Scan(&pr.ID, &pr.Name, &pr.AuthorID, &pr.Status)
+	var createdAt, updatedAt, mergedAt, closedAt sql.NullTime
+	err := q.QueryRowContext(ctx, `
+		SELECT id, title, authorId, status, created_at, updated_at, merged_at, closed_at
+		FROM pull_requests WHERE id = $1`, prID).
+		Scan(&pr.ID, &pr.Name, &pr.AuthorID, &pr.Status, &createdAt, &updatedAt, &mergedAt, &closedAt)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("%s: PR not found", op)
+			return nil, domain.ErrPRNotFound
 		}
-		return nil, fmt.Errorf("%s: %w", op, err)
+		return nil, err
 	}
+	pr.CreatedAt, pr.UpdatedAt = timePtr(createdAt), timePtr(updatedAt)
+	pr.MergedAt, pr.ClosedAt = timePtr(mergedAt), timePtr(closedAt)
 
-	rows, err := db.conn.Query(`SELECT user_id FROM pr_fk_reviewer WHERE pr_id = $1`, prID)
+	rows, err := q.QueryContext(ctx, `SELECT user_id, fallback, state, assigned_at FROM pr_fk_reviewer WHERE pr_id = $1 ORDER BY id`, prID)
 	if err != nil {
-		return nil, fmt.Errorf("%s: %w", op, err)
+		return nil, err
 	}
 	defer rows.Close()
 
 	var reviewers []string
+	pr.Reviews = make([]handlers.Review, 0)
 	for rows.Next() {
-		var userID string
-		if err := rows.Scan(&userID); err != nil {
-			return nil, fmt.Errorf("%s: %w", op, err)
+		var userID, state string
+		var fallback bool
+		var assignedAt sql.NullTime
+		if err := rows.Scan(&userID, &fallback, &state, &assignedAt); err != nil {
+			return nil, err
 		}
 		reviewers = append(reviewers, userID)
+		pr.Reviews = append(pr.Reviews, handlers.Review{ReviewerID: userID, State: state, AssignedAt: timePtr(assignedAt)})
+		if fallback {
+			pr.FallbackReviewers = append(pr.FallbackReviewers, userID)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
 	pr.AssignedReviewers = reviewers
 
 	return &pr, nil
 }
 
-func (db *DB) MergePullRequest(prID string) (*handlers.PullRequest, error) {
+// MergePullRequest merges the PR if it meets the merge policy of its
+// author's team, or unconditionally with force. Merging an already merged PR
+// is a no-op that reports merged as false.
+func (db *DB) MergePullRequest(ctx context.Context, actor, prID string, force bool) (*handlers.PullRequest, bool, error) {
 	const op = "Storage.MergePullRequest"
 
-	pr, err := db.GetPullRequest(prID)
-	if err != nil {
-		return nil, fmt.Errorf("%s: %w", op, err)
-	}
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	var pr *handlers.PullRequest
+	var merged bool
+	err := db.withTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		pr, err = getPullRequest(ctx, tx, prID)
+		if err != nil {
+			return err
+		}
 
-	_, err = db.conn.Exec(`UPDATE pull_requests SET status = 'MERGED' WHERE id = $1`, prID)
+		if pr.Status == handlers.PRStatusMerged {
+			return nil
+		}
+		if err := requireOpen(pr.Status, domain.ErrPRIsMerged); err != nil {
+			return err
+		}
+
+		if !force {
+			var teamName string
+			err = tx.QueryRowContext(ctx, `SELECT COALESCE(team_name, '') FROM users WHERE id = $1`, pr.AuthorID).Scan(&teamName)
+			if err != nil {
+				return err
+			}
+
+			settings, err := getTeamSettings(ctx, tx, teamName)
+			if err != nil {
+				return err
+			}
+
+			if unmet := unmetMergeConditions(settings.MergePolicy, pr.Reviews); len(unmet) > 0 {
+				return &domain.MergeBlockedError{Unmet: unmet}
+			}
+		}
+
+		mergedAt := now()
+		res, err := tx.ExecContext(ctx, `UPDATE pull_requests SET status = $1, merged_at = $2, updated_at = $2 WHERE id = $3 AND status = $4`,
+			handlers.PRStatusMerged, mergedAt, prID, handlers.PRStatusOpen)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			// A concurrent call changed the PR since it was read.
+			if pr, err = getPullRequest(ctx, tx, prID); err != nil {
+				return err
+			}
+			if pr.Status == handlers.PRStatusMerged {
+				return nil
+			}
+			return requireOpen(pr.Status, domain.ErrPRIsMerged)
+		}
+		merged = true
+
+		pr.Status = handlers.PRStatusMerged
+		pr.MergedAt, pr.UpdatedAt = &mergedAt, &mergedAt
+
+		event := handlers.AuditEvent{Actor: actor, Action: handlers.AuditPRMerged, PRID: prID}
+		if force {
+			event.Details = "forced"
+		}
+		return recordAudit(ctx, tx, event)
+	})
 	if err != nil {
-		return nil, fmt.Errorf("%s: %w", op, err)
+		return nil, false, fmt.Errorf("%s: %w", op, err)
 	}
 
-	pr.Status = "MERGED"
-	return pr, nil
+	return pr, merged, nil
 }
 
-func (db *DB) ReassignReviewer(prID, oldReviewerID string) (string, error) {
+func (db *DB) ReassignReviewer(ctx context.Context, actor, prID, oldReviewerID string) (string, error) {
 	const op = "Storage.ReassignReviewer"
 
-	pr, err := db.GetPullRequest(prID)
-	if err != nil {
-		return "", fmt.Errorf("%s: %w", op, err)
-	}
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
 
-	if pr.Status == "MERGED" {
-		return "", fmt.Errorf("%s: cannot reassign on merged PR", op)
-	}
+	var newReviewerID string
+	err := db.withTx(ctx, func(tx *sql.Tx) error {
+		pr, err := getPullRequest(ctx, tx, prID)
+		if err != nil {
+			return err
+		}
 
-	isAssigned := false
-	for _, reviewerID := range pr.AssignedReviewers {
-		if reviewerID == oldReviewerID {
-			isAssigned = true
-			break
+		if err := requireOpen(pr.Status, domain.ErrPRMerged); err != nil {
+			return err
 		}
-	}
-	if !isAssigned {
-		return "", fmt.Errorf("%s: reviewer is not assigned to this PR", op)
-	}
 
-	var oldReviewerTeam string
-	err = db.conn.QueryRow(`SELECT team_name FROM users WHERE id = $1`, oldReviewerID).Scan(&oldReviewerTeam)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return "", fmt.Errorf("%s: old reviewer not found", op)
+		isAssigned := false
+		for _, reviewerID := range pr.AssignedReviewers {
+			if reviewerID == oldReviewerID {
+				isAssigned = true
+				break
+			}
+		}
+		if !isAssigned {
+			return domain.ErrNotAssigned
 		}
+
+		r := db.newReassigner(ctx, tx, actor)
+		if newReviewerID, err = r.reassign(pr, oldReviewerID); err != nil {
+			return err
+		}
+		return r.flush()
+	})
+	if err != nil {
 		return "", fmt.Errorf("%s: %w", op, err)
 	}
 
-	excludeList := append(pr.AssignedReviewers, pr.AuthorID)
+	return newReviewerID, nil
+}
 
-	query := `
-		SELECT id FROM users 
-		WHERE team_name = $1 AND is_active = true
-	`
+// SubmitReview records the review of an assigned reviewer. A review can be
+// submitted any number of times; the last one wins.
+func (db *DB) SubmitReview(ctx context.Context, actor, prID, reviewerID, state string) (*handlers.PullRequest, error) {
+	const op = "Storage.SubmitReview"
 
-	args := []interface{}{oldReviewerTeam}
-	if len(excludeList) > 0 {
-		placeholders := ""
-		for i, userID := range excludeList {
-			if i > 0 {
-				placeholders += ", "
-			}
-			placeholders += fmt.Sprintf("$%d", i+2)
-			args = append(args, userID)
-		}
-		query += fmt.Sprintf(" AND id NOT IN (%s)", placeholders)
-	}
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
 
-	rows, err := db.conn.Query(query, args...)
-	if err != nil {
-		return "", fmt.Errorf("%s: %w", op, err)
+	if !handlers.IsReviewState(state) || state == handlers.ReviewPending {
+		return nil, fmt.Errorf("%s: %w: %q", op, domain.ErrInvalidReviewState, state)
 	}
-	defer rows.Close()
 
-	var candidates []string
-	for rows.Next() {
-		var userID string
-		if err := rows.Scan(&userID); err != nil {
-			return "", fmt.Errorf("%s: %w", op, err)
+	var pr *handlers.PullRequest
+	err := db.withTx(ctx, func(tx *sql.Tx) error {
+		current, err := getPullRequest(ctx, tx, prID)
+		if err != nil {
+			return err
 		}
-		candidates = append(candidates, userID)
-	}
 
-	if len(candidates) == 0 {
-		return "", fmt.Errorf("%s: no active replacement candidate in team", op)
-	}
+		if err := requireOpen(current.Status, domain.ErrReviewMerged); err != nil {
+			return err
+		}
+		if !slices.Contains(current.AssignedReviewers, reviewerID) {
+			return domain.ErrNotAssigned
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE pr_fk_reviewer SET state = $1 WHERE pr_id = $2 AND user_id = $3`, state, prID, reviewerID)
+		if err != nil {
+			return err
+		}
 
-	newReviewerID := selectRandomReviewers(candidates, 1)[0]
+		_, err = tx.ExecContext(ctx, `UPDATE pull_requests SET updated_at = $1 WHERE id = $2`, now(), prID)
+		if err != nil {
+			return err
+		}
 
-	_, err = db.conn.Exec(`UPDATE pr_fk_reviewer SET user_id = $1 WHERE pr_id = $2 AND user_id = $3`,
-		newReviewerID, prID, oldReviewerID)
+		err = recordAudit(ctx, tx, handlers.AuditEvent{
+			Actor:   actor,
+			Action:  handlers.AuditReviewSubmitted,
+			PRID:    prID,
+			UserID:  reviewerID,
+			Details: state,
+		})
+		if err != nil {
+			return err
+		}
+
+		pr, err = getPullRequest(ctx, tx, prID)
+		return err
+	})
 	if err != nil {
-		return "", fmt.Errorf("%s: %w", op, err)
+		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 
-	return newReviewerID, nil
+	return pr, nil
 }
 
-func (db *DB) GetPullRequestsByReviewer(userID string) ([]handlers.PullRequestShort, error) {
+func (db *DB) GetPullRequestsByReviewer(ctx context.Context, userID string, filter handlers.ReviewFilter) ([]handlers.PullRequestShort, error) {
 	const op = "Storage.GetPullRequestsByReviewer"
 
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	if err := validateReviewFilter(filter); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
 	query := `
-		SELECT DISTINCT pr.id, pr.title, pr.authorId, pr.status
+		SELECT pr.id, pr.title, pr.authorId, pr.status, pfr.state, pfr.assigned_at,
+			pr.created_at, pr.updated_at, pr.merged_at, pr.closed_at
 		FROM pull_requests pr
 		JOIN pr_fk_reviewer pfr ON pr.id = pfr.pr_id
 		WHERE pfr.user_id = $1
 	`
 
-	rows, err := db.conn.Query(query, userID)
+	args := []any{userID}
+	if len(filter.States) > 0 {
+		var list string
+		args, list = placeholders(args, filter.States)
+		query += " AND pfr.state IN (" + list + ")"
+	}
+
+	// PRs without created_at predate timestamps, so they count as the oldest.
+	switch filter.Sort {
+	case handlers.SortOldest:
+		query += " ORDER BY pr.created_at ASC NULLS FIRST, pr.id"
+	case handlers.SortNewest:
+		query += " ORDER BY pr.created_at DESC NULLS LAST, pr.id"
+	}
+
+	rows, err := db.conn.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
@@ -223,9 +415,15 @@ func (db *DB) GetPullRequestsByReviewer(userID string) ([]handlers.PullRequestSh
 	var prs []handlers.PullRequestShort
 	for rows.Next() {
 		var pr handlers.PullRequestShort
-		if err := rows.Scan(&pr.ID, &pr.Name, &pr.AuthorID, &pr.Status); err != nil {
+		var assignedAt, createdAt, updatedAt, mergedAt, closedAt sql.NullTime
+		err := rows.Scan(&pr.ID, &pr.Name, &pr.AuthorID, &pr.Status, &pr.ReviewState, &assignedAt,
+			&createdAt, &updatedAt, &mergedAt, &closedAt)
+		if err != nil {
 			return nil, fmt.Errorf("%s: %w", op, err)
 		}
+		pr.AssignedAt = timePtr(assignedAt)
+		pr.CreatedAt, pr.UpdatedAt = timePtr(createdAt), timePtr(updatedAt)
+		pr.MergedAt, pr.ClosedAt = timePtr(mergedAt), timePtr(closedAt)
 		prs = append(prs, pr)
 	}
 
@@ -236,23 +434,16 @@ func (db *DB) GetPullRequestsByReviewer(userID string) ([]handlers.PullRequestSh
 	return prs, nil
 }
 
-func selectRandomReviewers(candidates []string, maxCount int) []string {
-	if len(candidates) == 0 {
-		return []string{}
+func validateReviewFilter(filter handlers.ReviewFilter) error {
+	for _, state := range filter.States {
+		if !handlers.IsReviewState(state) {
+			return fmt.Errorf("%w: %q", domain.ErrInvalidReviewState, state)
+		}
 	}
 
-	count := maxCount
-	if len(candidates) < maxCount {
-		count = len(candidates)
+	switch filter.Sort {
+	case "", handlers.SortOldest, handlers.SortNewest:
+		return nil
 	}
-
-	rand.Seed(time.Now().UnixNano())
-
-	shuffled := make([]string, len(candidates))
-	copy(shuffled, candidates)
-	rand.Shuffle(len(shuffled), func(i, j int) {
-		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
-	})
-
-	return shuffled[:count]
+	return fmt.Errorf("%w: %q", domain.ErrInvalidSort, filter.Sort)
 }
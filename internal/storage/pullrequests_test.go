@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ten00m/golang-test-task/internal/http-server/handlers"
+	resp "github.com/ten00m/golang-test-task/internal/lib/api/response"
+)
+
+func TestCreatePullRequestConcurrentDuplicates(t *testing.T) {
+	for name, newStorage := range testBackends() {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			s := newStorage(t)
+
+			err := s.AddTeam(ctx, "test", handlers.Team{
+				Name: "backend",
+				Members: []handlers.User{
+					{ID: "u1", Username: "alice", IsActive: true},
+					{ID: "u2", Username: "bob", IsActive: true},
+					{ID: "u3", Username: "carol", IsActive: true},
+				},
+			})
+			if err != nil {
+				t.Fatalf("add team: %v", err)
+			}
+
+			srv := httptest.NewServer(handlers.NewPullRequestCreate(slog.New(slog.DiscardHandler), s))
+			defer srv.Close()
+
+			const n = 20
+			statuses := make([]int, n)
+			codes := make([]string, n)
+			var wg sync.WaitGroup
+			start := make(chan struct{})
+			for i := range n {
+				wg.Go(func() {
+					<-start
+					body := fmt.Sprintf(`{"pull_request_id":"pr-1","pull_request_name":"attempt %d","author_id":"u1"}`, i)
+					res, err := http.Post(srv.URL, "application/json", strings.NewReader(body))
+					if err != nil {
+						t.Errorf("create PR: %v", err)
+						return
+					}
+					defer res.Body.Close()
+
+					var r resp.Response
+					_ = json.NewDecoder(res.Body).Decode(&r)
+					statuses[i], codes[i] = res.StatusCode, r.Error.Code
+				})
+			}
+			close(start)
+			wg.Wait()
+
+			created := 0
+			for i, status := range statuses {
+				switch {
+				case status == http.StatusCreated:
+					created++
+				case status != http.StatusConflict || codes[i] != resp.CodePRExists:
+					t.Errorf("expected 409 PR_EXISTS, got %d %q", status, codes[i])
+				}
+			}
+			if created != 1 {
+				t.Fatalf("expected exactly one create to succeed, got %d", created)
+			}
+
+			pr, err := s.GetPullRequest(ctx, "pr-1")
+			if err != nil {
+				t.Fatalf("get PR: %v", err)
+			}
+			if len(pr.AssignedReviewers) != 2 {
+				t.Errorf("expected 2 reviewers, got %v", pr.AssignedReviewers)
+			}
+
+			if db, ok := s.(*DB); ok {
+				var orphans int
+				err := db.conn.QueryRowContext(ctx, `
+					SELECT COUNT(*) FROM pull_requests p
+					WHERE NOT EXISTS (SELECT 1 FROM pr_fk_reviewer r WHERE r.pr_id = p.id)`).Scan(&orphans)
+				if err != nil {
+					t.Fatalf("count PRs without reviewers: %v", err)
+				}
+				if orphans != 0 {
+					t.Errorf("expected no PRs without reviewers, got %d", orphans)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,356 @@
+package storage
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/ten00m/golang-test-task/internal/domain"
+	"github.com/ten00m/golang-test-task/internal/http-server/handlers"
+	"github.com/ten00m/golang-test-task/internal/reviewer"
+)
+
+// reassigner replaces reviewers within one transaction. It loads the
+// settings, candidates and members of every team it needs once and keeps
+// the open review counts of the candidates current itself, so reassigning
+// thousands of reviews does not query them again for each one. Writes that
+// can wait are batched until flush.
+type reassigner struct {
+	ctx   context.Context
+	db    *DB
+	tx    *sql.Tx
+	actor string
+
+	teams      map[string]*handlers.TeamSettings
+	candidates map[string][]reviewer.Candidate
+	userTeams  map[string]string
+
+	moves   []reviewerMove
+	touched []string
+	events  []handlers.AuditEvent
+}
+
+// reviewerMove is a reassignment whose pr_fk_reviewer row is not updated
+// yet.
+type reviewerMove struct {
+	prID          string
+	oldReviewerID string
+	newReviewerID string
+	fallback      bool
+}
+
+func (db *DB) newReassigner(ctx context.Context, tx *sql.Tx, actor string) *reassigner {
+	return &reassigner{
+		ctx:        ctx,
+		db:         db,
+		tx:         tx,
+		actor:      actor,
+		teams:      make(map[string]*handlers.TeamSettings),
+		candidates: make(map[string][]reviewer.Candidate),
+		userTeams:  make(map[string]string),
+	}
+}
+
+func (r *reassigner) settings(teamName string) (*handlers.TeamSettings, error) {
+	if settings, ok := r.teams[teamName]; ok {
+		return settings, nil
+	}
+	settings, err := getTeamSettings(r.ctx, r.tx, teamName)
+	if err != nil {
+		return nil, err
+	}
+	r.teams[teamName] = settings
+	return settings, nil
+}
+
+func (r *reassigner) userTeam(userID string) (string, error) {
+	if teamName, ok := r.userTeams[userID]; ok {
+		return teamName, nil
+	}
+	var teamName sql.NullString
+	err := r.tx.QueryRowContext(r.ctx, `SELECT team_name FROM users WHERE id = $1`, userID).Scan(&teamName)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", domain.ErrReviewerNotFound
+		}
+		return "", err
+	}
+	// Without a team there is no one to pick the replacement from.
+	if !teamName.Valid {
+		return "", domain.ErrNoCandidate
+	}
+	r.userTeams[userID] = teamName.String
+	return teamName.String, nil
+}
+
+// pick selects one reviewer to replace replaced the way selectReviewers
+// does: from teamName first, then from its fallback teams in order.
+func (r *reassigner) pick(teamName, replaced string, exclude []string) (picked string, fallback bool, err error) {
+	settings, err := r.settings(teamName)
+	if err != nil {
+		return "", false, err
+	}
+
+	teamNames := append([]string{teamName}, settings.FallbackTeams...)
+	for i, name := range teamNames {
+		teamSettings, err := r.settings(name)
+		if err != nil {
+			return "", false, err
+		}
+
+		candidates, ok := r.candidates[name]
+		if !ok {
+			// The open review counts come from pr_fk_reviewer, so it must
+			// have every move made so far.
+			if err := r.writeMoves(); err != nil {
+				return "", false, err
+			}
+			if candidates, err = loadCandidates(r.ctx, r.tx, name, nil); err != nil {
+				return "", false, err
+			}
+			r.candidates[name] = candidates
+		}
+
+		eligible := slices.DeleteFunc(slices.Clone(candidates), func(c reviewer.Candidate) bool {
+			return slices.Contains(exclude, c.UserID)
+		})
+		if picked := r.db.selectors.Select(teamSettings.ReviewerStrategy, name, eligible, 1); len(picked) > 0 {
+			r.countOpenReview(picked[0], 1)
+			r.countOpenReview(replaced, -1)
+			return picked[0], i > 0, nil
+		}
+	}
+
+	return "", false, domain.ErrNoCandidate
+}
+
+// countOpenReview changes the cached open review count of userID by delta,
+// if its team's candidates are loaded.
+func (r *reassigner) countOpenReview(userID string, delta int) {
+	for _, candidates := range r.candidates {
+		for i := range candidates {
+			if candidates[i].UserID == userID {
+				candidates[i].OpenReviews += delta
+			}
+		}
+	}
+}
+
+// reassign replaces oldReviewerID on pr with a reviewer picked by the rules
+// of the old reviewer's team and updates pr to match.
+func (r *reassigner) reassign(pr *handlers.PullRequest, oldReviewerID string) (string, error) {
+	teamName, err := r.userTeam(oldReviewerID)
+	if err != nil {
+		return "", err
+	}
+
+	exclude := append([]string{pr.AuthorID}, pr.AssignedReviewers...)
+	newReviewerID, fromFallback, err := r.pick(teamName, oldReviewerID, exclude)
+	if err != nil {
+		return "", err
+	}
+
+	// A replacement from the old reviewer's own team is a fallback
+	// reviewer exactly when the old reviewer was one.
+	wasFallback := slices.Contains(pr.FallbackReviewers, oldReviewerID)
+	isFallback := fromFallback || wasFallback
+
+	r.moves = append(r.moves, reviewerMove{
+		prID:          pr.ID,
+		oldReviewerID: oldReviewerID,
+		newReviewerID: newReviewerID,
+		fallback:      isFallback,
+	})
+	pr.AssignedReviewers[slices.Index(pr.AssignedReviewers, oldReviewerID)] = newReviewerID
+	if wasFallback {
+		pr.FallbackReviewers = slices.DeleteFunc(pr.FallbackReviewers, func(id string) bool { return id == oldReviewerID })
+	}
+	if isFallback {
+		pr.FallbackReviewers = append(pr.FallbackReviewers, newReviewerID)
+	}
+
+	event := handlers.AuditEvent{
+		Actor:         r.actor,
+		Action:        handlers.AuditReviewerReassigned,
+		PRID:          pr.ID,
+		OldReviewerID: oldReviewerID,
+		NewReviewerID: newReviewerID,
+		TeamName:      teamName,
+	}
+	if isFallback {
+		event.Details = "fallback"
+	}
+	r.events = append(r.events, event)
+	r.touched = append(r.touched, pr.ID)
+
+	return newReviewerID, nil
+}
+
+// writeMoves updates the pr_fk_reviewer rows of the queued moves, with one
+// statement per maxBatch bound values of moves that share the fallback
+// flag.
+func (r *reassigner) writeMoves() error {
+	assignedAt := now()
+	for _, fallback := range []bool{false, true} {
+		moves := slices.DeleteFunc(slices.Clone(r.moves), func(m reviewerMove) bool { return m.fallback != fallback })
+		for chunk := range slices.Chunk(moves, maxBatch/3) {
+			args := []any{assignedAt, fallback}
+			cases := make([]string, 0, len(chunk))
+			keys := make([]string, 0, len(chunk))
+			for _, m := range chunk {
+				n := len(args)
+				args = append(args, m.prID, m.oldReviewerID, m.newReviewerID)
+				cases = append(cases, fmt.Sprintf("WHEN pr_id = $%d AND user_id = $%d THEN $%d", n+1, n+2, n+3))
+				keys = append(keys, fmt.Sprintf("($%d, $%d)", n+1, n+2))
+			}
+
+			_, err := r.tx.ExecContext(r.ctx, `
+				UPDATE pr_fk_reviewer
+				SET user_id = CASE `+strings.Join(cases, " ")+` END,
+					fallback = $2, state = 'PENDING', assigned_at = $1
+				WHERE (pr_id, user_id) IN (`+strings.Join(keys, ", ")+`)`, args...)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	r.moves = nil
+	return nil
+}
+
+// flush writes the queued moves, touches updated_at of the reassigned PRs
+// and records the audit events.
+func (r *reassigner) flush() error {
+	if err := r.writeMoves(); err != nil {
+		return err
+	}
+
+	updatedAt := now()
+	for chunk := range slices.Chunk(slices.Compact(slices.Sorted(slices.Values(r.touched))), maxBatch) {
+		args, in := placeholders([]any{updatedAt}, chunk)
+		_, err := r.tx.ExecContext(r.ctx, `UPDATE pull_requests SET updated_at = $1 WHERE id IN (`+in+`)`, args...)
+		if err != nil {
+			return err
+		}
+	}
+	r.touched = nil
+
+	if err := recordAudits(r.ctx, r.tx, r.events); err != nil {
+		return err
+	}
+	r.events = nil
+
+	return nil
+}
+
+// reassignReviews moves the reviews of userIDs on OPEN PRs to other
+// reviewers; with authorTeam set, only on PRs of that team's members. The
+// users must already be inactive so that they are not picked for each
+// other's reviews. Reviews without a candidate stay where they are and are
+// reported as failed.
+func (db *DB) reassignReviews(ctx context.Context, tx *sql.Tx, actor string, userIDs []string, authorTeam string) (*handlers.ReassignReport, error) {
+	prs, err := openPullRequestsReviewedBy(ctx, tx, userIDs, authorTeam)
+	if err != nil {
+		return nil, err
+	}
+
+	leaving := make(map[string]bool, len(userIDs))
+	for _, userID := range userIDs {
+		leaving[userID] = true
+	}
+
+	r := db.newReassigner(ctx, tx, actor)
+	report := newReassignReport()
+	for _, pr := range prs {
+		reviewers := slices.Sorted(slices.Values(pr.AssignedReviewers))
+		for _, oldReviewerID := range reviewers {
+			if !leaving[oldReviewerID] {
+				continue
+			}
+
+			review := handlers.Reassignment{PRID: pr.ID, OldReviewerID: oldReviewerID}
+			review.NewReviewerID, err = r.reassign(pr, oldReviewerID)
+			if err != nil {
+				if !errors.Is(err, domain.ErrNoCandidate) {
+					return nil, err
+				}
+				review.Reason = err.Error()
+				report.Failed = append(report.Failed, review)
+				continue
+			}
+			report.Reassigned = append(report.Reassigned, review)
+		}
+	}
+
+	if err := r.flush(); err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// openPullRequestsReviewedBy loads the author and reviewers of every OPEN PR
+// reviewed by any of userIDs, ordered by ID. A non-empty authorTeam keeps
+// only the PRs authored by its members.
+func openPullRequestsReviewedBy(ctx context.Context, q queryer, userIDs []string, authorTeam string) ([]*handlers.PullRequest, error) {
+	// Every chunk that matches a PR returns all of its reviewers, so a PR
+	// is only filled in by the first chunk that loads it.
+	byID := make(map[string]*handlers.PullRequest)
+	for chunk := range slices.Chunk(userIDs, maxBatch) {
+		loaded := make(map[string]bool)
+		args, in := placeholders([]any{authorTeam}, chunk)
+		rows, err := q.QueryContext(ctx, `
+			SELECT p.id, p.authorId, r.user_id, r.fallback
+			FROM pull_requests p
+			JOIN pr_fk_reviewer r ON r.pr_id = p.id
+			WHERE p.status = 'OPEN' AND p.id IN (
+				SELECT pr_id FROM pr_fk_reviewer WHERE user_id IN (`+in+`)
+			) AND ($1 = '' OR p.authorId IN (SELECT id FROM users WHERE team_name = $1))
+			ORDER BY p.id, r.id`, args...)
+		if err != nil {
+			return nil, err
+		}
+
+		for rows.Next() {
+			var prID, authorID, reviewerID string
+			var fallback bool
+			if err := rows.Scan(&prID, &authorID, &reviewerID, &fallback); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			pr, ok := byID[prID]
+			if !ok {
+				pr = &handlers.PullRequest{ID: prID, AuthorID: authorID, Status: handlers.PRStatusOpen}
+				byID[prID] = pr
+				loaded[prID] = true
+			}
+			if !loaded[prID] {
+				continue
+			}
+			pr.AssignedReviewers = append(pr.AssignedReviewers, reviewerID)
+			if fallback {
+				pr.FallbackReviewers = append(pr.FallbackReviewers, reviewerID)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	prs := slices.Collect(maps.Values(byID))
+	slices.SortFunc(prs, func(a, b *handlers.PullRequest) int { return cmp.Compare(a.ID, b.ID) })
+	return prs, nil
+}
+
+func newReassignReport() *handlers.ReassignReport {
+	return &handlers.ReassignReport{
+		Reassigned: make([]handlers.Reassignment, 0),
+		Failed:     make([]handlers.Reassignment, 0),
+	}
+}
@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/ten00m/golang-test-task/internal/http-server/handlers"
+)
+
+func TestDeactivateTeamUsersAcrossBatches(t *testing.T) {
+	for name, newStorage := range testBackends() {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			s := newStorage(t)
+
+			// Only u0001 and the last member can review at first, so the PR
+			// gets reviewers that fall into different batches of maxBatch.
+			n := maxBatch + 100
+			last := fmt.Sprintf("u%04d", n-1)
+			members := make([]handlers.User, n)
+			for i := range members {
+				id := fmt.Sprintf("u%04d", i)
+				members[i] = handlers.User{ID: id, Username: "user-" + id, IsActive: i <= 1 || id == last}
+			}
+			team := handlers.Team{Name: "backend", Members: members}
+			if err := s.AddTeam(ctx, "test", team); err != nil {
+				t.Fatalf("add team: %v", err)
+			}
+			pr, err := s.CreatePullRequest(ctx, "test", "pr-1", "first", "u0000", false)
+			if err != nil {
+				t.Fatalf("create PR: %v", err)
+			}
+			if !slices.Equal(pr.AssignedReviewers, []string{"u0001", last}) &&
+				!slices.Equal(pr.AssignedReviewers, []string{last, "u0001"}) {
+				t.Fatalf("expected reviewers u0001 and %s, got %v", last, pr.AssignedReviewers)
+			}
+
+			for i := range members {
+				members[i].IsActive = true
+			}
+			if _, err := s.AddTeamMembers(ctx, "test", "backend", members); err != nil {
+				t.Fatalf("activate members: %v", err)
+			}
+
+			res, err := s.DeactivateTeamUsers(ctx, "test", "backend", []string{"u0000", "u0300"}, true)
+			if err != nil {
+				t.Fatalf("deactivate: %v", err)
+			}
+			if len(res.Deactivated) != n-2 {
+				t.Fatalf("expected %d users deactivated, got %d", n-2, len(res.Deactivated))
+			}
+
+			report := res.Reassignment
+			attempts := append(slices.Clone(report.Reassigned), report.Failed...)
+			var old []string
+			for _, a := range attempts {
+				if a.PRID != "pr-1" {
+					t.Errorf("unexpected PR in report: %+v", a)
+				}
+				old = append(old, a.OldReviewerID)
+			}
+			slices.Sort(old)
+			if !slices.Equal(old, []string{"u0001", last}) {
+				t.Errorf("expected one attempt for each of u0001 and %s, got %+v", last, attempts)
+			}
+
+			// u0300 is the only active candidate, so it takes exactly one
+			// review and the other stays with the deactivated user.
+			if len(report.Reassigned) != 1 || report.Reassigned[0].NewReviewerID != "u0300" {
+				t.Errorf("expected one review handed to u0300, got %+v", report.Reassigned)
+			}
+		})
+	}
+}
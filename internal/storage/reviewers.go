@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+
+	"github.com/ten00m/golang-test-task/internal/http-server/handlers"
+	"github.com/ten00m/golang-test-task/internal/reviewer"
+)
+
+// selectReviewers picks up to n active members of teamName, other than the
+// users in exclude, using the reviewer strategy from settings. When the team
+// cannot supply n reviewers, its fallback teams are asked in order; the
+// reviewers picked from them are also returned in fallback.
+func (db *DB) selectReviewers(ctx context.Context, q queryer, teamName string, settings *handlers.TeamSettings, exclude []string, n int) (picked, fallback []string, err error) {
+	candidates, err := loadCandidates(ctx, q, teamName, exclude)
+	if err != nil {
+		return nil, nil, err
+	}
+	picked = db.selectors.Select(settings.ReviewerStrategy, teamName, candidates, n)
+
+	for _, fallbackTeam := range settings.FallbackTeams {
+		if len(picked) >= n {
+			break
+		}
+
+		fallbackSettings, err := getTeamSettings(ctx, q, fallbackTeam)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		candidates, err := loadCandidates(ctx, q, fallbackTeam, slices.Concat(exclude, picked))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		extra := db.selectors.Select(fallbackSettings.ReviewerStrategy, fallbackTeam, candidates, n-len(picked))
+		picked = append(picked, extra...)
+		fallback = append(fallback, extra...)
+	}
+
+	return picked, fallback, nil
+}
+
+// loadCandidates returns the active members of teamName that are not absent,
+// except the users in exclude, with the number of OPEN pull requests each of them reviews and
+// their open review cap.
+func loadCandidates(ctx context.Context, q queryer, teamName string, exclude []string) ([]reviewer.Candidate, error) {
+	query := `
+		SELECT u.id, u.review_weight, u.max_open_reviews, COUNT(pr.id)
+		FROM users u
+		LEFT JOIN pr_fk_reviewer pfr ON pfr.user_id = u.id
+		LEFT JOIN pull_requests pr ON pr.id = pfr.pr_id AND pr.status = 'OPEN'
+		WHERE u.team_name = $1 AND u.is_active = true AND NOT EXISTS (
+			SELECT 1 FROM user_absences a
+			WHERE a.user_id = u.id AND a.starts_at <= $2 AND a.ends_at > $2
+		)
+	`
+
+	args := []any{teamName, now()}
+	if len(exclude) > 0 {
+		var list string
+		args, list = placeholders(args, exclude)
+		query += " AND u.id NOT IN (" + list + ")"
+	}
+	query += " GROUP BY u.id, u.review_weight, u.max_open_reviews"
+
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var candidates []reviewer.Candidate
+	for rows.Next() {
+		var c reviewer.Candidate
+		var maxOpenReviews sql.NullInt64
+		if err := rows.Scan(&c.UserID, &c.Weight, &maxOpenReviews, &c.OpenReviews); err != nil {
+			return nil, err
+		}
+		c.MaxOpenReviews = int(maxOpenReviews.Int64)
+		candidates = append(candidates, c)
+	}
+
+	return candidates, rows.Err()
+}
+
+// CountOpenReviewsByTeam returns the number of reviews assigned on OPEN PRs
+// by team of the reviewer. Reviewers without a team count under "".
+func (db *DB) CountOpenReviewsByTeam(ctx context.Context) (map[string]int, error) {
+	const op = "Storage.CountOpenReviewsByTeam"
+
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.conn.QueryContext(ctx, `
+		SELECT COALESCE(u.team_name, ''), COUNT(*)
+		FROM pr_fk_reviewer r
+		JOIN pull_requests p ON p.id = r.pr_id AND p.status = 'OPEN'
+		JOIN users u ON u.id = r.user_id
+		GROUP BY COALESCE(u.team_name, '')`)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var teamName string
+		var n int
+		if err := rows.Scan(&teamName, &n); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		counts[teamName] = n
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return counts, nil
+}
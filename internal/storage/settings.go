@@ -0,0 +1,210 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/ten00m/golang-test-task/internal/domain"
+	"github.com/ten00m/golang-test-task/internal/http-server/handlers"
+	"github.com/ten00m/golang-test-task/internal/reviewer"
+)
+
+// defaultReviewersPerPR is used when a team does not set reviewers_per_pr.
+const defaultReviewersPerPR = 2
+
+// normalizeSettings validates the settings of teamName and fills in defaults.
+// Whether the fallback teams exist is left to the foreign keys.
+func normalizeSettings(teamName string, settings handlers.TeamSettings) (handlers.TeamSettings, error) {
+	if !reviewer.IsKnown(settings.ReviewerStrategy) {
+		return settings, fmt.Errorf("%w: %s", domain.ErrUnknownStrategy, settings.ReviewerStrategy)
+	}
+	if settings.ReviewersPerPR < 0 {
+		return settings, fmt.Errorf("%w: reviewers_per_pr must be positive", domain.ErrInvalidSettings)
+	}
+	if settings.MinReviewers < 0 {
+		return settings, fmt.Errorf("%w: min_reviewers must not be negative", domain.ErrInvalidSettings)
+	}
+	if settings.ReviewersPerPR == 0 {
+		settings.ReviewersPerPR = defaultReviewersPerPR
+	}
+	if settings.MinReviewers > settings.ReviewersPerPR {
+		return settings, fmt.Errorf("%w: min_reviewers exceeds reviewers_per_pr", domain.ErrInvalidSettings)
+	}
+	if settings.MergePolicy.RequiredApprovals < 0 || settings.MergePolicy.RequiredApprovals > settings.ReviewersPerPR {
+		return settings, fmt.Errorf("%w: required_approvals must be between 0 and reviewers_per_pr", domain.ErrInvalidSettings)
+	}
+
+	seen := make(map[string]struct{}, len(settings.FallbackTeams))
+	for _, fallback := range settings.FallbackTeams {
+		if fallback == "" || fallback == teamName {
+			return settings, fmt.Errorf("%w: team cannot fall back to %q", domain.ErrInvalidSettings, fallback)
+		}
+		if _, ok := seen[fallback]; ok {
+			return settings, fmt.Errorf("%w: duplicate fallback team %s", domain.ErrInvalidSettings, fallback)
+		}
+		seen[fallback] = struct{}{}
+	}
+
+	return settings, nil
+}
+
+func (db *DB) GetTeamSettings(ctx context.Context, teamName string) (*handlers.TeamSettings, error) {
+	const op = "Storage.GetTeamSettings"
+
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	settings, err := getTeamSettings(ctx, db.conn, teamName)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return settings, nil
+}
+
+func getTeamSettings(ctx context.Context, q queryer, teamName string) (*handlers.TeamSettings, error) {
+	var settings handlers.TeamSettings
+	var strategy sql.NullString
+	err := q.QueryRowContext(ctx, `
+		SELECT reviewer_strategy, reviewers_per_pr, min_reviewers,
+			required_approvals, block_on_changes_requested, require_all_reviewers
+		FROM teams WHERE name = $1`, teamName).
+		Scan(&strategy, &settings.ReviewersPerPR, &settings.MinReviewers,
+			&settings.MergePolicy.RequiredApprovals, &settings.MergePolicy.BlockOnChangesRequested,
+			&settings.MergePolicy.RequireAllReviewers)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, domain.ErrTeamNotFound
+		}
+		return nil, err
+	}
+	settings.ReviewerStrategy = strategy.String
+
+	rows, err := q.QueryContext(ctx, `SELECT fallback_team FROM team_fallbacks WHERE team_name = $1 ORDER BY position`, teamName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var fallback string
+		if err := rows.Scan(&fallback); err != nil {
+			return nil, err
+		}
+		settings.FallbackTeams = append(settings.FallbackTeams, fallback)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return &settings, nil
+}
+
+// setFallbackTeams replaces the fallback teams of teamName.
+func setFallbackTeams(ctx context.Context, tx *sql.Tx, teamName string, fallbacks []string) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM team_fallbacks WHERE team_name = $1`, teamName)
+	if err != nil {
+		return err
+	}
+
+	for i, fallback := range fallbacks {
+		_, err := tx.ExecContext(ctx, `INSERT INTO team_fallbacks (team_name, fallback_team, position) VALUES ($1, $2, $3)`,
+			teamName, fallback, i)
+		if isForeignKeyViolation(err) {
+			return fmt.Errorf("%w: fallback team %s", domain.ErrTeamNotFound, fallback)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// UpdateTeamSettings replaces all settings of teamName.
+func (db *DB) UpdateTeamSettings(ctx context.Context, actor, teamName string, settings handlers.TeamSettings) (*handlers.TeamSettings, error) {
+	const op = "Storage.UpdateTeamSettings"
+
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	settings, err := normalizeSettings(teamName, settings)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	err = db.withTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, `
+			UPDATE teams SET reviewer_strategy = $1, reviewers_per_pr = $2, min_reviewers = $3,
+				required_approvals = $4, block_on_changes_requested = $5, require_all_reviewers = $6
+			WHERE name = $7`,
+			nullString(settings.ReviewerStrategy), settings.ReviewersPerPR, settings.MinReviewers,
+			settings.MergePolicy.RequiredApprovals, settings.MergePolicy.BlockOnChangesRequested,
+			settings.MergePolicy.RequireAllReviewers, teamName)
+		if err != nil {
+			return err
+		}
+
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if affected == 0 {
+			return domain.ErrTeamNotFound
+		}
+
+		if err := setFallbackTeams(ctx, tx, teamName, settings.FallbackTeams); err != nil {
+			return err
+		}
+
+		return recordAudit(ctx, tx, handlers.AuditEvent{
+			Actor:    actor,
+			Action:   handlers.AuditTeamSettingsUpdated,
+			TeamName: teamName,
+			Details:  settingsDetails(settings),
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return &settings, nil
+}
+
+// unmetMergeConditions returns the conditions of policy that a PR with the
+// given reviews does not meet; none means the PR can be merged.
+func unmetMergeConditions(policy handlers.MergePolicy, reviews []handlers.Review) []string {
+	var unmet []string
+
+	approvals := 0
+	for _, review := range reviews {
+		if review.State == handlers.ReviewApproved {
+			approvals++
+		}
+	}
+	if approvals < policy.RequiredApprovals {
+		unmet = append(unmet, fmt.Sprintf("%d approvals required, got %d", policy.RequiredApprovals, approvals))
+	}
+
+	for _, review := range reviews {
+		switch {
+		case policy.BlockOnChangesRequested && review.State == handlers.ReviewChangesRequested:
+			unmet = append(unmet, fmt.Sprintf("changes requested by %s", review.ReviewerID))
+		case policy.RequireAllReviewers && review.State == handlers.ReviewPending:
+			unmet = append(unmet, fmt.Sprintf("no review from %s yet", review.ReviewerID))
+		}
+	}
+
+	return unmet
+}
+
+// settingsDetails renders settings for the audit log.
+func settingsDetails(settings handlers.TeamSettings) string {
+	details, err := json.Marshal(settings)
+	if err != nil {
+		return ""
+	}
+	return string(details)
+}
@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/ten00m/golang-test-task/internal/config"
+	"github.com/ten00m/golang-test-task/internal/reviewer"
+
+	"github.com/XSAM/otelsql"
+	semconv "go.opentelemetry.io/otel/semconv/v1.40.0"
+	_ "modernc.org/sqlite"
+)
+
+// NewSQLite opens (creating if needed) the SQLite file at cfg.Path. The
+// returned DB shares every query with the Postgres backend.
+func NewSQLite(ctx context.Context, cfg *config.SQLiteConfig, log *slog.Logger) (*DB, error) {
+	dsn := fmt.Sprintf(
+		"file:%s?_pragma=foreign_keys(1)&_pragma=journal_mode(WAL)&_pragma=busy_timeout(%d)&_txlock=immediate",
+		cfg.Path,
+		cfg.BusyTimeout.Milliseconds(),
+	)
+
+	conn, err := otelsql.Open("sqlite", dsn, sqlTracing(semconv.DBSystemNameSQLite)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database file: %w", err)
+	}
+
+	if err := conn.PingContext(ctx); err != nil {
+		return nil, fmt.Errorf("failed to ping database: %w", err)
+	}
+
+	log.Info("successfully opened sqlite database", slog.String("path", cfg.Path))
+
+	db := &DB{
+		conn:                 conn,
+		log:                  log,
+		driver:               config.DriverSQLite,
+		selectors:            reviewer.NewDefaultRegistry(),
+		reassignOnDeactivate: true,
+	}
+
+	return db, nil
+}
@@ -1,209 +1,290 @@
 package storage
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log/slog"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/ten00m/golang-test-task/internal/config"
+	"github.com/ten00m/golang-test-task/internal/http-server/handlers"
+	"github.com/ten00m/golang-test-task/internal/reviewer"
+	"github.com/ten00m/golang-test-task/internal/storage/migrations"
+	"github.com/ten00m/golang-test-task/internal/tracing"
 
+	"github.com/XSAM/otelsql"
 	_ "github.com/lib/pq"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.40.0"
 )
 
-type DB struct {
-	conn *sql.DB
-	log  *slog.Logger
+// Storage is implemented by every storage backend the service can run on.
+type Storage interface {
+	AddTeam(ctx context.Context, actor string, team handlers.Team) error
+	GetTeam(ctx context.Context, teamName string) (handlers.Team, error)
+	AddTeamMembers(ctx context.Context, actor, teamName string, members []handlers.User) (handlers.Team, error)
+	RemoveTeamMembers(ctx context.Context, actor, teamName string, userIDs []string) (handlers.Team, error)
+	RenameTeam(ctx context.Context, actor, teamName, newName string) (handlers.Team, error)
+	DeleteTeam(ctx context.Context, actor, teamName string) error
+	GetTeamSettings(ctx context.Context, teamName string) (*handlers.TeamSettings, error)
+	UpdateTeamSettings(ctx context.Context, actor, teamName string, settings handlers.TeamSettings) (*handlers.TeamSettings, error)
+
+	SetUserIsActive(ctx context.Context, actor, userID string, isActive bool) (*handlers.User, *handlers.ReassignReport, error)
+	GetUser(ctx context.Context, userID string) (*handlers.User, error)
+	MoveUserToTeam(ctx context.Context, actor, userID, teamName string, handover bool) (*handlers.User, *handlers.ReassignReport, error)
+	DeactivateTeamUsers(ctx context.Context, actor, teamName string, userIDs []string, allExcept bool) (*handlers.TeamDeactivation, error)
+
+	AddAbsence(ctx context.Context, actor string, absence handlers.Absence) (*handlers.Absence, error)
+	GetAbsences(ctx context.Context, userID string) ([]handlers.Absence, error)
+	DeleteAbsence(ctx context.Context, actor string, absenceID int64) error
+	ReassignAbsentReviewers(ctx context.Context, actor string) (*handlers.ReassignReport, error)
+
+	CreatePullRequest(ctx context.Context, actor, prID, prName, authorID string, draft bool) (*handlers.PullRequest, error)
+	GetPullRequest(ctx context.Context, prID string) (*handlers.PullRequest, error)
+	ReadyPullRequest(ctx context.Context, actor, prID string) (*handlers.PullRequest, error)
+	ClosePullRequest(ctx context.Context, actor, prID string) (*handlers.PullRequest, error)
+	ReopenPullRequest(ctx context.Context, actor, prID string) (*handlers.PullRequest, error)
+	// MergePullRequest reports with merged whether this call merged the PR,
+	// as opposed to finding it merged already.
+	MergePullRequest(ctx context.Context, actor, prID string, force bool) (pr *handlers.PullRequest, merged bool, err error)
+	ReassignReviewer(ctx context.Context, actor, prID, oldReviewerID string) (string, error)
+	SubmitReview(ctx context.Context, actor, prID, reviewerID, state string) (*handlers.PullRequest, error)
+	GetPullRequestsByReviewer(ctx context.Context, userID string, filter handlers.ReviewFilter) ([]handlers.PullRequestShort, error)
+	CountOpenReviewsByTeam(ctx context.Context) (map[string]int, error)
+
+	Ping(ctx context.Context) error
+	MigrationState(ctx context.Context) (*handlers.MigrationState, error)
+
+	GetAuditEvents(ctx context.Context, filter handlers.AuditFilter) (*handlers.AuditPage, error)
+
+	Close() error
 }
 
-func New(cfg *config.PostgreSQLConfig, log *slog.Logger) (*DB, error) {
-	dsn := fmt.Sprintf(
-		"host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
-		cfg.Host,
-		cfg.Port,
-		cfg.User,
-		cfg.Password,
-		cfg.Database,
-	)
+var (
+	_ Storage = (*DB)(nil)
+	_ Storage = (*Memory)(nil)
+)
 
-	conn, err := sql.Open("postgres", dsn)
+// NewFromConfig creates the storage backend selected by cfg.Storage.Driver
+// and, unless auto_migrate is off, brings its schema up to date.
+func NewFromConfig(ctx context.Context, cfg *config.Config, log *slog.Logger) (Storage, error) {
+	selectors, err := reviewer.NewRegistry(cfg.Reviewers.Strategy, cfg.Reviewers.MaxOpenReviews)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open database connection: %w", err)
+		return nil, err
 	}
 
-	if err := conn.Ping(); err != nil {
-		return nil, fmt.Errorf("failed to ping database: %w", err)
+	if cfg.Storage.Driver == config.DriverMemory {
+		m := NewMemory(log)
+		m.selectors = selectors
+		m.reassignOnDeactivate = cfg.Reviewers.ReassignOnDeactivate
+		return m, nil
 	}
 
-	log.Info("successfully connected to database")
-
-	db := &DB{
-		conn: conn,
-		log:  log,
-	}
-
-	if err := db.initializeTables(); err != nil {
+	db, err := NewSQL(ctx, cfg, log)
+	if err != nil {
 		return nil, err
 	}
+	db.selectors = selectors
+	db.reassignOnDeactivate = cfg.Reviewers.ReassignOnDeactivate
+	db.queryTimeout = cfg.Storage.QueryTimeout
+	db.bulkQueryTimeout = cfg.Storage.BulkQueryTimeout
+
+	if cfg.Storage.AutoMigrate {
+		if err := db.Migrate(ctx); err != nil {
+			_ = db.Close()
+			return nil, err
+		}
+	}
 
 	return db, nil
 }
 
-func (db *DB) initializeTables() error {
-	const op = "Storage.initializeTables"
-
-	if err := db.createTeamsTable(); err != nil {
-		return fmt.Errorf("%s: %w", op, err)
-	}
-
-	if err := db.createUsersTable(); err != nil {
-		return fmt.Errorf("%s: %w", op, err)
-	}
-
-	if err := db.createPullRequestsTable(); err != nil {
-		return fmt.Errorf("%s: %w", op, err)
+// NewSQL opens the SQL database selected by cfg.Storage.Driver without
+// touching its schema.
+func NewSQL(ctx context.Context, cfg *config.Config, log *slog.Logger) (*DB, error) {
+	switch cfg.Storage.Driver {
+	case config.DriverPostgres:
+		return New(ctx, &cfg.PostgreSQL, log)
+	case config.DriverSQLite:
+		return NewSQLite(ctx, &cfg.SQLite, log)
+	default:
+		return nil, fmt.Errorf("storage driver %q has no SQL database", cfg.Storage.Driver)
 	}
+}
 
-	if err := db.createTeamFkUserTable(); err != nil {
-		return fmt.Errorf("%s: %w", op, err)
-	}
+// DB is the SQL-backed Storage. It talks to Postgres when created with New
+// and to an embedded SQLite file when created with NewSQLite; queries are
+// written once, with $N placeholders understood by both drivers.
+type DB struct {
+	conn      *sql.DB
+	log       *slog.Logger
+	driver    string
+	selectors *reviewer.Registry
+	// reassignOnDeactivate makes SetUserIsActive reassign the open reviews
+	// of users it deactivates.
+	reassignOnDeactivate bool
+	// queryTimeout limits every storage call and bulkQueryTimeout the ones
+	// that hand over reviews; zero means no limit.
+	queryTimeout     time.Duration
+	bulkQueryTimeout time.Duration
+}
 
-	if err := db.createPrFkReviewerTable(); err != nil {
-		return fmt.Errorf("%s: %w", op, err)
+// sqlTracing makes the database report a span for every statement, as a
+// child of the span in the context of the call.
+func sqlTracing(system attribute.KeyValue) []otelsql.Option {
+	return []otelsql.Option{
+		otelsql.WithAttributes(system),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{
+			OmitConnResetSession: true,
+			OmitRows:             true,
+		}),
 	}
-
-	return nil
 }
 
-func (db *DB) createUsersTable() error {
-	const op = "Storage.createUsersTable"
-
-	query := `
-		CREATE TABLE IF NOT EXISTS users(
-			id TEXT PRIMARY KEY,
-			username TEXT NOT NULL UNIQUE,
-			is_active BOOLEAN NOT NULL,
-			team_name TEXT,
-			FOREIGN KEY (team_name) REFERENCES teams(name)
-		);
-	`
-
-	stmt, err := db.conn.Prepare(query)
+// New connects to Postgres. It keeps retrying for cfg.ConnectTimeout, so
+// the service may start before the database is ready.
+func New(ctx context.Context, cfg *config.PostgreSQLConfig, log *slog.Logger) (*DB, error) {
+	conn, err := otelsql.Open("postgres", postgresDSN(cfg), sqlTracing(semconv.DBSystemNamePostgreSQL)...)
 	if err != nil {
-		return fmt.Errorf("%s: %w", op, err)
+		return nil, fmt.Errorf("failed to open database connection: %w", err)
 	}
-	defer stmt.Close()
+	conn.SetMaxOpenConns(cfg.MaxOpenConns)
+	conn.SetMaxIdleConns(cfg.MaxIdleConns)
+	conn.SetConnMaxLifetime(cfg.ConnMaxLifetime)
 
-	_, err = stmt.Exec()
-	if err != nil {
-		return fmt.Errorf("%s: %w", op, err)
+	if err := ping(ctx, conn, cfg.ConnectTimeout, log); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
-	return nil
-}
+	log.Info("successfully connected to database")
 
-func (db *DB) createTeamsTable() error {
-	const op = "Storage.createTeamsTable"
+	db := &DB{
+		conn:                 conn,
+		log:                  log,
+		driver:               config.DriverPostgres,
+		selectors:            reviewer.NewDefaultRegistry(),
+		reassignOnDeactivate: true,
+	}
 
-	query := `
-		CREATE TABLE IF NOT EXISTS teams(
-			name TEXT PRIMARY KEY
-		);
-	`
+	return db, nil
+}
 
-	stmt, err := db.conn.Prepare(query)
-	if err != nil {
-		return fmt.Errorf("%s: %w", op, err)
+// postgresDSN returns cfg.DSN if set, or builds a key=value connection
+// string from the separate settings otherwise.
+func postgresDSN(cfg *config.PostgreSQLConfig) string {
+	if cfg.DSN != "" {
+		return cfg.DSN
 	}
-	defer stmt.Close()
 
-	_, err = stmt.Exec()
-	if err != nil {
-		return fmt.Errorf("%s: %w", op, err)
+	params := []struct{ key, value string }{
+		{"host", cfg.Host},
+		{"port", strconv.Itoa(cfg.Port)},
+		{"user", cfg.User},
+		{"password", cfg.Password},
+		{"dbname", cfg.Database},
+		{"sslmode", cfg.SSLMode},
+		{"sslrootcert", cfg.SSLRootCert},
+		{"sslcert", cfg.SSLCert},
+		{"sslkey", cfg.SSLKey},
 	}
 
-	return nil
+	quote := strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+	pairs := make([]string, 0, len(params))
+	for _, p := range params {
+		if p.value == "" {
+			continue
+		}
+		pairs = append(pairs, p.key+"='"+quote.Replace(p.value)+"'")
+	}
+	return strings.Join(pairs, " ")
 }
 
-func (db *DB) createPullRequestsTable() error {
-	const op = "Storage.createPullRequestsTable"
-
-	query := `
-		CREATE TABLE IF NOT EXISTS pull_requests(
-			id TEXT PRIMARY KEY,
-			title TEXT NOT NULL,
-			authorId TEXT NOT NULL,
-			status VARCHAR(10) NOT NULL CHECK (status IN ('OPEN', 'MERGED')),
-			FOREIGN KEY (authorId) REFERENCES users(id)
-		);
-	`
-
-	stmt, err := db.conn.Prepare(query)
-	if err != nil {
-		return fmt.Errorf("%s: %w", op, err)
-	}
-	defer stmt.Close()
+// ping checks that the database is reachable, retrying with exponential
+// backoff until timeout has passed or ctx is done.
+func ping(ctx context.Context, conn *sql.DB, timeout time.Duration, log *slog.Logger) error {
+	const (
+		maxBackoff = 5 * time.Second
+		// minAttempt lets the last attempt, or the only one with a zero
+		// timeout, finish a connect that is already under way.
+		minAttempt = time.Second
+	)
 
-	_, err = stmt.Exec()
-	if err != nil {
-		return fmt.Errorf("%s: %w", op, err)
+	deadline := time.Now().Add(timeout)
+	backoff := 250 * time.Millisecond
+	for {
+		attempt := max(min(time.Until(deadline), maxBackoff), minAttempt)
+		attemptCtx, cancel := context.WithTimeout(ctx, attempt)
+		err := conn.PingContext(attemptCtx)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		wait := min(backoff, time.Until(deadline))
+		if wait <= 0 {
+			return err
+		}
+
+		log.Warn("database is not ready, retrying",
+			slog.String("error", err.Error()),
+			slog.String("backoff", wait.String()),
+		)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		backoff = min(backoff*2, maxBackoff)
 	}
+}
 
-	return nil
+// Migrator returns a Migrator for the schema of db's driver.
+func (db *DB) Migrator() (*migrations.Migrator, error) {
+	return migrations.New(db.conn, db.driver, db.log)
 }
 
-func (db *DB) createTeamFkUserTable() error {
-	const op = "Storage.createTeamFkUserTable"
+// Migrate applies every pending schema migration.
+func (db *DB) Migrate(ctx context.Context) error {
+	const op = "Storage.Migrate"
 
-	query := `
-		CREATE TABLE IF NOT EXISTS team_fk_user(
-			id SERIAL PRIMARY KEY,
-			team_name TEXT NOT NULL,
-			user_id TEXT NOT NULL,
-			FOREIGN KEY (team_name) REFERENCES teams(name),
-			FOREIGN KEY (user_id) REFERENCES users(id)
-		);
-	`
+	ctx, span := otel.Tracer(tracing.Name).Start(ctx, op)
+	defer span.End()
 
-	stmt, err := db.conn.Prepare(query)
+	m, err := db.Migrator()
 	if err != nil {
 		return fmt.Errorf("%s: %w", op, err)
 	}
-	defer stmt.Close()
 
-	_, err = stmt.Exec()
-	if err != nil {
+	if err := m.Up(ctx); err != nil {
 		return fmt.Errorf("%s: %w", op, err)
 	}
 
 	return nil
 }
 
-// createPrFkReviewerTable creates the pr_fk_reviewer table
-func (db *DB) createPrFkReviewerTable() error {
-	const op = "Storage.createPrFkReviewerTable"
-
-	query := `
-		CREATE TABLE IF NOT EXISTS pr_fk_reviewer(
-			id SERIAL PRIMARY KEY,
-			pr_id TEXT NOT NULL,
-			user_id TEXT NOT NULL,
-			FOREIGN KEY (pr_id) REFERENCES pull_requests(id),
-			FOREIGN KEY (user_id) REFERENCES users(id)
-		);
-	`
-
-	stmt, err := db.conn.Prepare(query)
+func (db *DB) Ping(ctx context.Context) error {
+	return db.conn.PingContext(ctx)
+}
+
+func (db *DB) MigrationState(ctx context.Context) (*handlers.MigrationState, error) {
+	const op = "Storage.MigrationState"
+
+	m, err := db.Migrator()
 	if err != nil {
-		return fmt.Errorf("%s: %w", op, err)
+		return nil, fmt.Errorf("%s: %w", op, err)
 	}
-	defer stmt.Close()
 
-	_, err = stmt.Exec()
+	current, pending, err := m.State(ctx)
 	if err != nil {
-		return fmt.Errorf("%s: %w", op, err)
+		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 
-	return nil
+	return &handlers.MigrationState{Current: current, Latest: m.Latest(), Pending: pending}, nil
 }
 
 func (db *DB) Close() error {
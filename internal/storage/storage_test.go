@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ten00m/golang-test-task/internal/config"
+)
+
+// testPostgresDSN names the environment variable with the DSN of a Postgres
+// database for the tests. Each test gets a schema of its own there, dropped
+// when it ends; without the variable the Postgres variants are skipped.
+const testPostgresDSN = "TEST_PSQL_DSN"
+
+// testBackends returns a constructor of an empty, migrated storage for
+// every backend the tests run against.
+func testBackends() map[string]func(t *testing.T) Storage {
+	return map[string]func(t *testing.T) Storage{
+		"sqlite":   func(t *testing.T) Storage { return newTestSQLite(t) },
+		"postgres": func(t *testing.T) Storage { return newTestPostgres(t) },
+		"memory":   func(t *testing.T) Storage { return NewMemory(slog.New(slog.DiscardHandler)) },
+	}
+}
+
+func newTestSQLite(t *testing.T) *DB {
+	t.Helper()
+
+	ctx := context.Background()
+	db, err := NewSQLite(ctx, &config.SQLiteConfig{
+		Path:        filepath.Join(t.TempDir(), "test.db"),
+		BusyTimeout: 5 * time.Second,
+	}, slog.New(slog.DiscardHandler))
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	if err := db.Migrate(ctx); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return db
+}
+
+func newTestPostgres(t *testing.T) *DB {
+	t.Helper()
+
+	dsn := os.Getenv(testPostgresDSN)
+	if dsn == "" {
+		t.Skipf("%s is not set", testPostgresDSN)
+	}
+
+	ctx := context.Background()
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("open postgres: %v", err)
+	}
+	defer admin.Close()
+
+	schema := fmt.Sprintf("test_%d", time.Now().UnixNano())
+	if _, err := admin.ExecContext(ctx, "CREATE SCHEMA "+schema); err != nil {
+		t.Fatalf("create schema: %v", err)
+	}
+	t.Cleanup(func() {
+		admin, err := sql.Open("postgres", dsn)
+		if err != nil {
+			t.Errorf("open postgres: %v", err)
+			return
+		}
+		defer admin.Close()
+		if _, err := admin.ExecContext(context.Background(), "DROP SCHEMA "+schema+" CASCADE"); err != nil {
+			t.Errorf("drop schema: %v", err)
+		}
+	})
+
+	db, err := New(ctx, &config.PostgreSQLConfig{
+		DSN:            withSearchPath(dsn, schema),
+		ConnectTimeout: 5 * time.Second,
+	}, slog.New(slog.DiscardHandler))
+	if err != nil {
+		t.Fatalf("connect to postgres: %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	if err := db.Migrate(ctx); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return db
+}
+
+// withSearchPath makes the connections of dsn, a postgres:// URL or
+// key=value pairs, use schema.
+func withSearchPath(dsn, schema string) string {
+	if !strings.Contains(dsn, "://") {
+		return dsn + " search_path=" + schema
+	}
+
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return dsn
+	}
+	q := u.Query()
+	q.Set("search_path", schema)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
@@ -0,0 +1,274 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+
+	"github.com/ten00m/golang-test-task/internal/domain"
+	"github.com/ten00m/golang-test-task/internal/http-server/handlers"
+)
+
+// AddTeamMembers adds users to an existing team. Users that are already
+// members are updated; users of another team are refused, they have to be
+// moved instead.
+func (db *DB) AddTeamMembers(ctx context.Context, actor, teamName string, members []handlers.User) (handlers.Team, error) {
+	const op = "Storage.AddTeamMembers"
+
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	for _, user := range members {
+		if user.MaxOpenReviews < 0 {
+			return handlers.Team{}, fmt.Errorf("%s: %w: max_open_reviews of %s", op, domain.ErrInvalidSettings, user.ID)
+		}
+	}
+
+	var team handlers.Team
+	err := db.withTx(ctx, func(tx *sql.Tx) error {
+		if _, err := getTeamSettings(ctx, tx, teamName); err != nil {
+			return err
+		}
+
+		members = slices.Clone(members)
+		for i, user := range members {
+			if user.ID != "" {
+				var current sql.NullString
+				err := tx.QueryRowContext(ctx, `SELECT team_name FROM users WHERE id = $1`, user.ID).Scan(&current)
+				if err != nil && err != sql.ErrNoRows {
+					return err
+				}
+				if current.Valid && current.String != teamName {
+					return fmt.Errorf("%w: %s is in team %s", domain.ErrUserInOtherTeam, user.ID, current.String)
+				}
+			}
+			members[i].TeamName = teamName
+		}
+
+		if err := addFKsForTeamsAndUsers(ctx, tx, members, teamName); err != nil {
+			return err
+		}
+
+		var err error
+		team, err = getTeam(ctx, tx, teamName)
+		if err != nil {
+			return err
+		}
+
+		events := make([]handlers.AuditEvent, 0, len(members))
+		for _, user := range members {
+			events = append(events, handlers.AuditEvent{
+				Actor:    actor,
+				Action:   handlers.AuditTeamMemberAdded,
+				UserID:   memberID(team, user),
+				TeamName: teamName,
+			})
+		}
+		return recordAudits(ctx, tx, events)
+	})
+	if err != nil {
+		return handlers.Team{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return team, nil
+}
+
+// memberID returns the ID of user, which may have been generated on insert,
+// by looking up its username among the members of team.
+func memberID(team handlers.Team, user handlers.User) string {
+	if user.ID != "" {
+		return user.ID
+	}
+	for _, member := range team.Members {
+		if member.Username == user.Username {
+			return member.ID
+		}
+	}
+	return ""
+}
+
+// RemoveTeamMembers takes users out of their team. Users that author or
+// review OPEN or DRAFT PRs are refused; their work has to be handed over
+// first.
+func (db *DB) RemoveTeamMembers(ctx context.Context, actor, teamName string, userIDs []string) (handlers.Team, error) {
+	const op = "Storage.RemoveTeamMembers"
+
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	var team handlers.Team
+	err := db.withTx(ctx, func(tx *sql.Tx) error {
+		if _, err := getTeamSettings(ctx, tx, teamName); err != nil {
+			return err
+		}
+
+		events := make([]handlers.AuditEvent, 0, len(userIDs))
+		for _, userID := range userIDs {
+			var current sql.NullString
+			err := tx.QueryRowContext(ctx, `SELECT team_name FROM users WHERE id = $1`, userID).Scan(&current)
+			if err == sql.ErrNoRows || (err == nil && current.String != teamName) {
+				return fmt.Errorf("%w: %s is not a member of team %s", domain.ErrUserNotFound, userID, teamName)
+			}
+			if err != nil {
+				return err
+			}
+
+			var openPRs int
+			err = tx.QueryRowContext(ctx, `
+				SELECT COUNT(*) FROM pull_requests p
+				WHERE p.status IN ('DRAFT', 'OPEN') AND (p.authorId = $1
+					OR EXISTS (SELECT 1 FROM pr_fk_reviewer r WHERE r.pr_id = p.id AND r.user_id = $1))`,
+				userID).Scan(&openPRs)
+			if err != nil {
+				return err
+			}
+			if openPRs > 0 {
+				return fmt.Errorf("%w: %s has %d", domain.ErrUserHasOpenPRs, userID, openPRs)
+			}
+
+			if _, err := tx.ExecContext(ctx, `UPDATE users SET team_name = NULL WHERE id = $1`, userID); err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, `DELETE FROM team_fk_user WHERE user_id = $1`, userID); err != nil {
+				return err
+			}
+
+			events = append(events, handlers.AuditEvent{
+				Actor:    actor,
+				Action:   handlers.AuditTeamMemberRemoved,
+				UserID:   userID,
+				TeamName: teamName,
+			})
+		}
+		if err := recordAudits(ctx, tx, events); err != nil {
+			return err
+		}
+
+		var err error
+		team, err = getTeam(ctx, tx, teamName)
+		return err
+	})
+	if err != nil {
+		return handlers.Team{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return team, nil
+}
+
+// RenameTeam renames a team along with every reference to it. Past audit
+// events keep the old name.
+func (db *DB) RenameTeam(ctx context.Context, actor, teamName, newName string) (handlers.Team, error) {
+	const op = "Storage.RenameTeam"
+
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	var team handlers.Team
+	err := db.withTx(ctx, func(tx *sql.Tx) error {
+		if _, err := getTeamSettings(ctx, tx, teamName); err != nil {
+			return err
+		}
+
+		// Nothing references teams with ON UPDATE CASCADE, so the team is
+		// copied under the new name, the references are moved over and the
+		// old row is dropped.
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO teams (name, reviewer_strategy, reviewers_per_pr, min_reviewers,
+				required_approvals, block_on_changes_requested, require_all_reviewers)
+			SELECT $1, reviewer_strategy, reviewers_per_pr, min_reviewers,
+				required_approvals, block_on_changes_requested, require_all_reviewers
+			FROM teams WHERE name = $2`, newName, teamName)
+		if isUniqueViolation(err) {
+			return domain.ErrTeamExists
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, stmt := range []string{
+			`UPDATE users SET team_name = $1 WHERE team_name = $2`,
+			`UPDATE team_fk_user SET team_name = $1 WHERE team_name = $2`,
+			`UPDATE team_fallbacks SET team_name = $1 WHERE team_name = $2`,
+			`UPDATE team_fallbacks SET fallback_team = $1 WHERE fallback_team = $2`,
+		} {
+			if _, err := tx.ExecContext(ctx, stmt, newName, teamName); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM teams WHERE name = $1`, teamName); err != nil {
+			return err
+		}
+
+		err = recordAudit(ctx, tx, handlers.AuditEvent{
+			Actor:    actor,
+			Action:   handlers.AuditTeamRenamed,
+			TeamName: newName,
+			Details:  "renamed from " + teamName,
+		})
+		if err != nil {
+			return err
+		}
+
+		team, err = getTeam(ctx, tx, newName)
+		return err
+	})
+	if err != nil {
+		return handlers.Team{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return team, nil
+}
+
+// DeleteTeam deletes a team that has no members and is not a fallback team
+// of another team.
+func (db *DB) DeleteTeam(ctx context.Context, actor, teamName string) error {
+	const op = "Storage.DeleteTeam"
+
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	err := db.withTx(ctx, func(tx *sql.Tx) error {
+		if _, err := getTeamSettings(ctx, tx, teamName); err != nil {
+			return err
+		}
+
+		var members int
+		if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM users WHERE team_name = $1`, teamName).Scan(&members); err != nil {
+			return err
+		}
+		if members > 0 {
+			return fmt.Errorf("%w: %d members", domain.ErrTeamNotEmpty, members)
+		}
+
+		var dependents int
+		err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM team_fallbacks WHERE fallback_team = $1`, teamName).Scan(&dependents)
+		if err != nil {
+			return err
+		}
+		if dependents > 0 {
+			return fmt.Errorf("%w: used by %d teams", domain.ErrTeamInUse, dependents)
+		}
+
+		for _, stmt := range []string{
+			`DELETE FROM team_fk_user WHERE team_name = $1`,
+			`DELETE FROM team_fallbacks WHERE team_name = $1`,
+			`DELETE FROM teams WHERE name = $1`,
+		} {
+			if _, err := tx.ExecContext(ctx, stmt, teamName); err != nil {
+				return err
+			}
+		}
+
+		return recordAudit(ctx, tx, handlers.AuditEvent{
+			Actor:    actor,
+			Action:   handlers.AuditTeamDeleted,
+			TeamName: teamName,
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
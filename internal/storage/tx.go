@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// queryer is implemented by both *sql.DB and *sql.Tx, so helpers can run
+// either inside or outside of a transaction.
+type queryer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// withTimeout bounds ctx by the query timeout, so a stuck statement gives
+// its connection back instead of holding it until the client goes away.
+func (db *DB) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return boundBy(ctx, db.queryTimeout)
+}
+
+// withBulkTimeout is withTimeout for the calls that hand over the reviews
+// of many users at once.
+func (db *DB) withBulkTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return boundBy(ctx, db.bulkQueryTimeout)
+}
+
+func boundBy(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// withTx runs fn inside a transaction, committing it if fn returns nil and
+// rolling it back otherwise.
+func (db *DB) withTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	if err := fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// isUniqueViolation reports whether err was caused by a PRIMARY KEY or
+// UNIQUE constraint on either of the supported drivers.
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "23505"
+	}
+
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		code := sqliteErr.Code()
+		return code == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY || code == sqlite3.SQLITE_CONSTRAINT_UNIQUE
+	}
+
+	return false
+}
+
+// isForeignKeyViolation reports whether err was caused by a FOREIGN KEY
+// constraint on either of the supported drivers.
+func isForeignKeyViolation(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "23503"
+	}
+
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_FOREIGNKEY
+	}
+
+	return false
+}
+
+// maxBatch caps the rows or values a single batched statement handles, well
+// within the bind parameter limits of both drivers.
+const maxBatch = 500
+
+// placeholders appends values to args and returns the matching "$n, $m, ..."
+// list for an IN clause.
+func placeholders[T any](args []any, values []T) ([]any, string) {
+	list := make([]string, 0, len(values))
+	for _, value := range values {
+		args = append(args, value)
+		list = append(list, fmt.Sprintf("$%d", len(args)))
+	}
+	return args, strings.Join(list, ", ")
+}
+
+// now is the time stored for events happening now. It is cut to the
+// microsecond precision of Postgres so values read back compare equal.
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
+
+// timePtr converts a nullable timestamp column to UTC.
+func timePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	utc := t.Time.UTC()
+	return &utc
+}
+
+// nullString stores empty strings as NULL.
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
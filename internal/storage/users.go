@@ -1,43 +1,246 @@
 package storage
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"slices"
 
+	"github.com/ten00m/golang-test-task/internal/domain"
 	"github.com/ten00m/golang-test-task/internal/http-server/handlers"
 )
 
-func (db *DB) SetUserIsActive(userID string, isActive bool) (*handlers.User, error) {
+// userColumns are the users columns read by scanUser, in order. Users
+// removed from their team have no team_name.
+const userColumns = `id, username, COALESCE(team_name, ''), is_active, review_weight, max_open_reviews`
+
+func scanUser(row interface{ Scan(dest ...any) error }) (handlers.User, error) {
+	var user handlers.User
+	var maxOpenReviews sql.NullInt64
+	err := row.Scan(&user.ID, &user.Username, &user.TeamName, &user.IsActive, &user.ReviewWeight, &maxOpenReviews)
+	user.MaxOpenReviews = int(maxOpenReviews.Int64)
+	return user, err
+}
+
+// SetUserIsActive activates or deactivates a user. Unless reassignment on
+// deactivation is off, the open reviews of a deactivated user are reassigned
+// in the same transaction and the returned report tells how that went; it is
+// nil otherwise.
+func (db *DB) SetUserIsActive(ctx context.Context, actor, userID string, isActive bool) (*handlers.User, *handlers.ReassignReport, error) {
 	const op = "Storage.SetUserIsActive"
 
+	ctx, cancel := db.withBulkTimeout(ctx)
+	defer cancel()
+
 	var user handlers.User
-	err := db.conn.QueryRow(`SELECT id, username, team_name, is_active FROM users WHERE id = $1`, userID).
-		Scan(&user.ID, &user.Username, &user.TeamName, &user.IsActive)
+	var report *handlers.ReassignReport
+	err := db.withTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		user, err = scanUser(tx.QueryRowContext(ctx, `SELECT `+userColumns+` FROM users WHERE id = $1`, userID))
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return domain.ErrUserNotFound
+			}
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE users SET is_active = $1 WHERE id = $2`, isActive, userID)
+		if err != nil {
+			return err
+		}
+
+		action := handlers.AuditUserDeactivated
+		if isActive {
+			action = handlers.AuditUserActivated
+		}
+		err = recordAudit(ctx, tx, handlers.AuditEvent{
+			Actor:    actor,
+			Action:   action,
+			UserID:   userID,
+			TeamName: user.TeamName,
+		})
+		if err != nil {
+			return err
+		}
+
+		if isActive || !db.reassignOnDeactivate {
+			return nil
+		}
+		report, err = db.reassignReviews(ctx, tx, actor, []string{userID}, "")
+		return err
+	})
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("%s: user not found", op)
+		return nil, nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	user.IsActive = isActive
+	return &user, report, nil
+}
+
+// MoveUserToTeam moves a user to another team. With handover their reviews of
+// OPEN PRs authored in the old team are first reassigned within the old
+// team's rules, and the report tells how that went; it is nil otherwise.
+func (db *DB) MoveUserToTeam(ctx context.Context, actor, userID, teamName string, handover bool) (*handlers.User, *handlers.ReassignReport, error) {
+	const op = "Storage.MoveUserToTeam"
+
+	ctx, cancel := db.withBulkTimeout(ctx)
+	defer cancel()
+
+	var user handlers.User
+	var report *handlers.ReassignReport
+	err := db.withTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		user, err = scanUser(tx.QueryRowContext(ctx, `SELECT `+userColumns+` FROM users WHERE id = $1`, userID))
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return domain.ErrUserNotFound
+			}
+			return err
 		}
-		return nil, fmt.Errorf("%s: %w", op, err)
+		if _, err := getTeamSettings(ctx, tx, teamName); err != nil {
+			return err
+		}
+
+		oldTeam := user.TeamName
+		if oldTeam == teamName {
+			return nil
+		}
+
+		// The handover runs while the user still belongs to the old team,
+		// so the reassigner looks the replacements up there.
+		if handover && oldTeam != "" {
+			report, err = db.reassignReviews(ctx, tx, actor, []string{userID}, oldTeam)
+			if err != nil {
+				return err
+			}
+		}
+
+		if _, err := tx.ExecContext(ctx, `UPDATE users SET team_name = $1 WHERE id = $2`, teamName, userID); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM team_fk_user WHERE user_id = $1`, userID); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO team_fk_user (team_name, user_id) VALUES ($1, $2)`, teamName, userID); err != nil {
+			return err
+		}
+		user.TeamName = teamName
+
+		event := handlers.AuditEvent{Actor: actor, Action: handlers.AuditUserMoved, UserID: userID, TeamName: teamName}
+		if oldTeam != "" {
+			event.Details = "moved from " + oldTeam
+		}
+		return recordAudit(ctx, tx, event)
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", op, err)
 	}
 
-	_, err = db.conn.Exec(`UPDATE users SET is_active = $1 WHERE id = $2`, isActive, userID)
+	return &user, report, nil
+}
+
+// DeactivateTeamUsers deactivates userIDs, or with allExcept every other
+// member of the team, and reassigns all their open reviews in one
+// transaction.
+func (db *DB) DeactivateTeamUsers(ctx context.Context, actor, teamName string, userIDs []string, allExcept bool) (*handlers.TeamDeactivation, error) {
+	const op = "Storage.DeactivateTeamUsers"
+
+	ctx, cancel := db.withBulkTimeout(ctx)
+	defer cancel()
+
+	result := &handlers.TeamDeactivation{TeamName: teamName, Deactivated: make([]string, 0)}
+	err := db.withTx(ctx, func(tx *sql.Tx) error {
+		if _, err := getTeamSettings(ctx, tx, teamName); err != nil {
+			return err
+		}
+
+		rows, err := tx.QueryContext(ctx, `SELECT id, is_active FROM users WHERE team_name = $1`, teamName)
+		if err != nil {
+			return err
+		}
+		members := make(map[string]bool)
+		var memberIDs []string
+		for rows.Next() {
+			var id string
+			var isActive bool
+			if err := rows.Scan(&id, &isActive); err != nil {
+				rows.Close()
+				return err
+			}
+			members[id] = isActive
+			memberIDs = append(memberIDs, id)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		slices.Sort(memberIDs)
+
+		targets, err := deactivationTargets(teamName, memberIDs, userIDs, allExcept)
+		if err != nil {
+			return err
+		}
+
+		var events []handlers.AuditEvent
+		for _, id := range targets {
+			if members[id] {
+				result.Deactivated = append(result.Deactivated, id)
+				events = append(events, handlers.AuditEvent{
+					Actor:    actor,
+					Action:   handlers.AuditUserDeactivated,
+					UserID:   id,
+					TeamName: teamName,
+				})
+			}
+		}
+		for chunk := range slices.Chunk(result.Deactivated, maxBatch) {
+			args, in := placeholders(nil, chunk)
+			if _, err := tx.ExecContext(ctx, `UPDATE users SET is_active = false WHERE id IN (`+in+`)`, args...); err != nil {
+				return err
+			}
+		}
+		if err := recordAudits(ctx, tx, events); err != nil {
+			return err
+		}
+
+		result.Reassignment, err = db.reassignReviews(ctx, tx, actor, targets, "")
+		return err
+	})
 	if err != nil {
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 
-	user.IsActive = isActive
-	return &user, nil
+	return result, nil
 }
 
-func (db *DB) GetUser(userID string) (*handlers.User, error) {
+// deactivationTargets resolves the users DeactivateTeamUsers acts on out of
+// the sorted memberIDs of teamName.
+func deactivationTargets(teamName string, memberIDs, userIDs []string, allExcept bool) ([]string, error) {
+	if allExcept {
+		return slices.DeleteFunc(slices.Clone(memberIDs), func(id string) bool {
+			return slices.Contains(userIDs, id)
+		}), nil
+	}
+
+	targets := slices.Sorted(slices.Values(userIDs))
+	for _, id := range targets {
+		if _, ok := slices.BinarySearch(memberIDs, id); !ok {
+			return nil, fmt.Errorf("%w: %s is not a member of team %s", domain.ErrUserNotFound, id, teamName)
+		}
+	}
+	return slices.Compact(targets), nil
+}
+
+func (db *DB) GetUser(ctx context.Context, userID string) (*handlers.User, error) {
 	const op = "Storage.GetUser"
 
-	var user handlers.User
-	err := db.conn.QueryRow(`SELECT id, username, team_name, is_active FROM users WHERE id = $1`, userID).
-		Scan(&user.ID, &user.Username, &user.TeamName, &user.IsActive)
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	user, err := scanUser(db.conn.QueryRowContext(ctx, `SELECT `+userColumns+` FROM users WHERE id = $1`, userID))
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("%s: user not found", op)
+			return nil, fmt.Errorf("%s: %w", op, domain.ErrUserNotFound)
 		}
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}